@@ -13,7 +13,8 @@ import (
 var (
 	mutex sync.Mutex
 	data  = make(map[*http.Request]map[interface{}]interface{})
-	datat = make(map[*http.Request]int64)
+	datat = make(map[*http.Request]int64) // creation, in seconds.
+	dataa = make(map[*http.Request]int64) // last access, in nanoseconds.
 )
 
 // Set stores a value for a given key in a given request.
@@ -24,6 +25,7 @@ func Set(r *http.Request, key, val interface{}) {
 		data[r] = make(map[interface{}]interface{})
 		datat[r] = time.Now().Unix()
 	}
+	dataa[r] = time.Now().UnixNano()
 	data[r][key] = val
 }
 
@@ -32,11 +34,25 @@ func Get(r *http.Request, key interface{}) interface{} {
 	mutex.Lock()
 	defer mutex.Unlock()
 	if data[r] != nil {
+		dataa[r] = time.Now().UnixNano()
 		return data[r][key]
 	}
 	return nil
 }
 
+// GetOk returns a value stored for a given key in a given request, and
+// true if the key was set. It distinguishes a missing key from a nil value.
+func GetOk(r *http.Request, key interface{}) (interface{}, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if data[r] != nil {
+		dataa[r] = time.Now().UnixNano()
+		value, ok := data[r][key]
+		return value, ok
+	}
+	return nil, false
+}
+
 // Delete removes a value stored for a given key in a given request.
 func Delete(r *http.Request, key interface{}) {
 	mutex.Lock()
@@ -60,6 +76,7 @@ func Clear(r *http.Request) {
 func clear(r *http.Request) {
 	delete(data, r)
 	delete(datat, r)
+	delete(dataa, r)
 }
 
 // Purge removes request data stored for longer than maxAge, in seconds.
@@ -70,22 +87,59 @@ func clear(r *http.Request) {
 // This is only used for sanity check: in case context cleaning was not
 // properly set some request data can be kept forever, consuming an increasing
 // amount of memory. In case this is detected, Purge() must be called
-// periodically until the problem is fixed.
+// periodically until the problem is fixed. See also PurgeIdle(), which
+// removes request data by last access instead.
 func Purge(maxAge int) int {
 	mutex.Lock()
 	defer mutex.Unlock()
-	count := 0
 	if maxAge <= 0 {
-		count = len(data)
-		data = make(map[*http.Request]map[interface{}]interface{})
-		datat = make(map[*http.Request]int64)
-	} else {
-		min := time.Now().Unix() - int64(maxAge)
-		for r, _ := range data {
-			if datat[r] < min {
-				clear(r)
-				count++
-			}
+		return purgeAll()
+	}
+	return purgeBefore(datat, time.Now().Unix()-int64(maxAge))
+}
+
+// PurgeIdle removes request data not accessed, with Set() or Get(), for
+// longer than olderThan. It returns the amount of requests removed.
+//
+// If olderThan <= 0, all request data is removed.
+//
+// Requests can be abandoned without clearing their data, for example when
+// a handler that is not wrapped with ClearHandler() panics, or when the
+// connection is hijacked. As a safety net, PurgeIdle() can be called
+// periodically from a goroutine:
+//
+//	go func() {
+//		for _ = range time.Tick(time.Minute) {
+//			context.PurgeIdle(10 * time.Minute)
+//		}
+//	}()
+func PurgeIdle(olderThan time.Duration) int {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if olderThan <= 0 {
+		return purgeAll()
+	}
+	return purgeBefore(dataa, time.Now().Add(-olderThan).UnixNano())
+}
+
+// purgeAll removes all request data. It returns the amount of requests
+// removed.
+func purgeAll() int {
+	count := len(data)
+	data = make(map[*http.Request]map[interface{}]interface{})
+	datat = make(map[*http.Request]int64)
+	dataa = make(map[*http.Request]int64)
+	return count
+}
+
+// purgeBefore removes request data with a time in times before min. It
+// returns the amount of requests removed.
+func purgeBefore(times map[*http.Request]int64, min int64) int {
+	count := 0
+	for r, _ := range data {
+		if times[r] < min {
+			clear(r)
+			count++
 		}
 	}
 	return count
@@ -7,6 +7,7 @@ package context
 import (
 	"net/http"
 	"testing"
+	"time"
 )
 
 type keyType int
@@ -14,6 +15,8 @@ type keyType int
 const (
 	key1 keyType = iota
 	key2
+	key3
+	key4
 )
 
 func TestContext(t *testing.T) {
@@ -37,6 +40,21 @@ func TestContext(t *testing.T) {
 	assertEqual(Get(r, key2), "2")
 	assertEqual(len(data[r]), 2)
 
+	// GetOk()
+	value, ok := GetOk(r, key1)
+	assertEqual(value, "1")
+	assertEqual(ok, true)
+
+	Set(r, key3, nil)
+	value, ok = GetOk(r, key3)
+	assertEqual(value, nil)
+	assertEqual(ok, true)
+
+	value, ok = GetOk(r, key4)
+	assertEqual(value, nil)
+	assertEqual(ok, false)
+	Delete(r, key3)
+
 	// Delete()
 	Delete(r, key1)
 	assertEqual(Get(r, key1), nil)
@@ -49,4 +67,94 @@ func TestContext(t *testing.T) {
 	// Clear()
 	Clear(r)
 	assertEqual(len(data), 0)
+
+	// No-ops for a request without values.
+	Delete(r, key1)
+	value, ok = GetOk(r, key1)
+	assertEqual(value, nil)
+	assertEqual(ok, false)
+	assertEqual(len(data), 0)
+}
+
+func TestClearHandler(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	h := ClearHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Set(r, key1, "1")
+		if Get(r, key1) != "1" {
+			t.Errorf("Expected the value to be set during the request")
+		}
+	}))
+	h.ServeHTTP(nil, r)
+	if _, ok := data[r]; ok {
+		t.Errorf("Expected request values to be cleared")
+	}
+
+	// Values are also cleared if the handler panics.
+	h = ClearHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Set(r, key1, "1")
+		panic("handler error")
+	}))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected the panic to be propagated")
+			}
+		}()
+		h.ServeHTTP(nil, r)
+	}()
+	if _, ok := data[r]; ok {
+		t.Errorf("Expected request values to be cleared after a panic")
+	}
+}
+
+func TestPurgeIdle(t *testing.T) {
+	r1, _ := http.NewRequest("GET", "http://localhost:8080/1", nil)
+	r2, _ := http.NewRequest("GET", "http://localhost:8080/2", nil)
+	Set(r1, key1, "1")
+	Set(r2, key1, "2")
+
+	// Make r1 look idle for an hour; r2 was just accessed.
+	dataa[r1] = time.Now().Add(-time.Hour).UnixNano()
+	if n := PurgeIdle(time.Minute); n != 1 {
+		t.Errorf("Expected 1 request purged, got %d", n)
+	}
+	if _, ok := GetOk(r1, key1); ok {
+		t.Errorf("Expected r1 values to be purged")
+	}
+	if Get(r2, key1) != "2" {
+		t.Errorf("Expected r2 values to be kept")
+	}
+
+	// Get() updates the access time.
+	dataa[r2] = time.Now().Add(-time.Hour).UnixNano()
+	Get(r2, key1)
+	if n := PurgeIdle(time.Minute); n != 0 {
+		t.Errorf("Expected no requests purged, got %d", n)
+	}
+
+	if n := Purge(0); n != 1 || len(data) != 0 || len(datat) != 0 || len(dataa) != 0 {
+		t.Errorf("Expected all request data purged, got %d", n)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	r1, _ := http.NewRequest("GET", "http://localhost:8080/1", nil)
+	r2, _ := http.NewRequest("GET", "http://localhost:8080/2", nil)
+	Set(r1, key1, "1")
+	Set(r2, key1, "2")
+
+	// Purge() uses the creation time: r1 was created an hour ago, even if
+	// it was just accessed.
+	datat[r1] = time.Now().Add(-time.Hour).Unix()
+	Set(r1, key2, "3")
+	if n := Purge(60); n != 1 {
+		t.Errorf("Expected 1 request purged, got %d", n)
+	}
+	if _, ok := GetOk(r1, key1); ok {
+		t.Errorf("Expected r1 values to be purged")
+	}
+	if Get(r2, key1) != "2" {
+		t.Errorf("Expected r2 values to be kept")
+	}
+	Clear(r2)
 }
@@ -75,6 +75,7 @@ served. Just call Clear() passing the request:
 variables at the end of a request lifetime.
 
 The Router from the package gorilla/mux calls Clear(), so if you are using it
-you don't need to clear the context manually.
+you don't need to clear the context manually. Wrapping the router with
+ClearHandler() is harmless: clearing twice has no effect.
 */
 package context
@@ -53,6 +53,14 @@ calling mux.Vars():
 	vars := mux.Vars(request)
 	category := vars["category"]
 
+Variables can also be decoded into a struct with typed fields calling
+mux.DecodeVars(), which uses the gorilla/schema package for conversions.
+
+The pattern can also be the name of a variable converter: "int", "uuid" and
+"slug" are built in, and more can be added with Router.RegisterVarConverter():
+
+	r.HandleFunc("/articles/{category:slug}/{id:int}", ArticleHandler)
+
 And this is all you need to know about the basic usage. More advanced options
 are explained below.
 
@@ -77,6 +85,10 @@ There are several other matchers that can be added. To match path prefixes:
 
 	r.Schemes("https")
 
+...or ports:
+
+	r.Port("8443")
+
 ...or header values:
 
 	r.Headers("X-Requested-With", "XMLHttpRequest")
@@ -85,6 +97,10 @@ There are several other matchers that can be added. To match path prefixes:
 
 	r.Queries("key", "value")
 
+...including variables in the values:
+
+	r.Queries("page", "{page:[0-9]+}")
+
 ...or to use a custom matcher function:
 
 	r.MatcherFunc(myFunc)
@@ -96,6 +112,13 @@ There are several other matchers that can be added. To match path prefixes:
 	  Methods("GET").
 	  Schemes("http")
 
+A combination of matchers can also be defined once as a Matchers set and
+added to several routes, together with the matchers of each route:
+
+	api := mux.Matchers{}.Host("api.domain.com").Schemes("https")
+	r.HandleFunc("/users", UsersHandler).ApplyMatchers(api)
+	r.HandleFunc("/orders", OrdersHandler).ApplyMatchers(api).Methods("POST")
+
 Setting the same matching conditions again and again can be boring, so we have
 a way to group several routes that share the same requirements.
 We call it "subrouting".
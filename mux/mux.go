@@ -7,9 +7,14 @@ package mux
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"code.google.com/p/gorilla/context"
+	"code.google.com/p/gorilla/schema"
 )
 
 // NewRouter returns a new router instance.
@@ -38,6 +43,12 @@ func NewRouter() *Router {
 type Router struct {
 	// Configurable Handler to be used when no route matches.
 	NotFoundHandler http.Handler
+	// Configurable Handler to be used when a route matches the request
+	// except for the HTTP method. The allowed methods can be retrieved
+	// calling mux.AllowedMethods(request). If nil, a handler that responds
+	// with 405 and an Allow header listing the allowed methods is used.
+	// A subrouter handler takes precedence for its routes.
+	MethodNotAllowedHandler http.Handler
 	// Parent route, if this is a subrouter.
 	parent parentRoute
 	// Routes to be matched, in order.
@@ -46,35 +57,169 @@ type Router struct {
 	namedRoutes map[string]*Route
 	// See Router.StrictSlash(). This defines the flag for new routes.
 	strictSlash bool
+	// See Router.SlashPolicy(). This defines the policy for new routes.
+	slashPolicy SlashPolicy
+	// See Router.SetRegexpFlags(). This defines the flags for new routes.
+	regexpFlags string
+	// See Router.RegisterVarConverter(). Converters for new routes.
+	varConverters map[string]varConverter
+	// See Router.Recover().
+	recoverHandler RecoverFunc
+	// See Router.FormatExtensions().
+	formatExts []string
+	// See Router.TrustForwardedFor(). This defines the flag for new routes.
+	trustForwardedFor bool
+	// See Router.CollapseSlashes().
+	collapseSlashes bool
+	// See Router.SkipClean().
+	skipClean bool
+	// See Router.ServerTiming().
+	serverTiming bool
+	// See Router.Freeze().
+	frozen bool
+	// See Router.Use().
+	middlewares []func(http.Handler) http.Handler
 }
 
 // Match matches registered routes against the request.
 func (r *Router) Match(req *http.Request, match *RouteMatch) bool {
+	if p, format := r.stripFormat(req.URL.Path); format != "" {
+		saved := *match
+		orig := req.URL.Path
+		req.URL.Path = p
+		match.format = format
+		matched := r.match(req, match)
+		req.URL.Path = orig
+		if matched {
+			if match.Vars == nil {
+				match.Vars = make(map[string]string)
+			}
+			if _, ok := match.Vars["format"]; !ok {
+				match.Vars["format"] = format
+			}
+			return true
+		}
+		// Match the full path from the initial state, so that a method
+		// mismatch for the path without extension is not reported.
+		*match = saved
+	}
+	return r.match(req, match)
+}
+
+// match matches registered routes against the request, in order.
+func (r *Router) match(req *http.Request, match *RouteMatch) bool {
 	for _, route := range r.routes {
 		if matched := route.Match(req, match); matched {
+			if match.Handler != nil && len(r.middlewares) > 0 {
+				match.Handler = chain(match.Handler, r.middlewares)
+			}
 			return true
 		}
 	}
+	if match.MatchErr == ErrMethodMismatch && match.methodNotAllowed == nil {
+		match.methodNotAllowed = r.MethodNotAllowedHandler
+	}
 	return false
 }
 
+// MatchAll returns the matches for all routes that match a request with the
+// given method and URL, in the order they were registered.
+//
+// Unlike Match(), it doesn't stop at the first matching route. Routes from
+// subrouters are tested individually. It is intended for tests, e.g. to
+// assert that a URL matches exactly one route, and not for serving requests.
+// It returns nil if the URL can't be parsed.
+func (r *Router) MatchAll(method, url string) []*RouteMatch {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil
+	}
+	return r.matchAll(req)
+}
+
+// matchAll returns the matches for all routes that match the request.
+func (r *Router) matchAll(req *http.Request) []*RouteMatch {
+	var matches []*RouteMatch
+	for _, route := range r.routes {
+		var sub *Router
+		for _, m := range route.matchers {
+			if router, ok := m.(*Router); ok {
+				sub = router
+			}
+		}
+		if sub == nil {
+			if match := new(RouteMatch); route.Match(req, match) {
+				matches = append(matches, match)
+			}
+			continue
+		}
+		// Test the subrouter routes if the other matchers match.
+		if route.buildOnly || route.err != nil {
+			continue
+		}
+		matched := true
+		for _, m := range route.matchers {
+			if m != matcher(sub) && !m.Match(req, new(RouteMatch)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, sub.matchAll(req)...)
+		}
+	}
+	return matches
+}
+
 // ServeHTTP dispatches the handler registered in the matched route.
 //
 // When there is a match, the route variables can be retrieved calling
 // mux.Vars(request).
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	// Clean path to canonical form and redirect.
-	if p := cleanPath(req.URL.Path); p != req.URL.Path {
-		w.Header().Set("Location", p)
-		w.WriteHeader(http.StatusMovedPermanently)
-		return
+	if r.collapseSlashes {
+		req.URL.Path = collapseSlashes(req.URL.Path)
 	}
 	var match RouteMatch
+	var matched bool
+	var start time.Time
+	if r.serverTiming {
+		start = time.Now()
+	}
+	if r.skipClean {
+		// Match the raw path, keeping encoded characters.
+		// Redirects are built from the original URL, so that encoded
+		// characters are not escaped twice.
+		orig := *req.URL
+		match.origURL = &orig
+		req.URL.Path = req.URL.EscapedPath()
+		matched = r.Match(req, &match)
+		*req.URL = orig
+	} else {
+		// Clean path to canonical form and redirect.
+		if p := cleanPath(req.URL.Path); p != req.URL.Path {
+			w.Header().Set("Location", p)
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		matched = r.Match(req, &match)
+	}
+	if r.serverTiming {
+		w.Header().Add("Server-Timing", serverTiming(match.Route, matched,
+			time.Since(start)))
+	}
 	var handler http.Handler
-	if matched := r.Match(req, &match); matched {
+	if matched {
 		handler = match.Handler
-		setVars(req, match.Vars)
+		if match.Vars != nil {
+			setVars(req, match.Vars)
+		}
 		setCurrentRoute(req, match.Route)
+	} else if match.MatchErr == ErrMethodMismatch {
+		handler = match.methodNotAllowed
+		if handler == nil {
+			handler = http.HandlerFunc(methodNotAllowed)
+		}
+		setAllowedMethods(req, match.allowedMethods)
 	}
 	if handler == nil {
 		if r.NotFoundHandler == nil {
@@ -83,9 +228,72 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		handler = r.NotFoundHandler
 	}
 	defer context.Clear(req)
+	if r.recoverHandler != nil {
+		defer r.recover(w, req)
+	}
 	handler.ServeHTTP(w, req)
 }
 
+// serverTiming returns a Server-Timing header value for the duration of
+// route matching. The description is the name or the path template of the
+// matched route, if any.
+func serverTiming(route *Route, matched bool, d time.Duration) string {
+	desc := ""
+	if matched && route != nil {
+		if desc = route.GetName(); desc == "" {
+			desc, _ = route.GetPathTemplate()
+		}
+	}
+	dur := strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	if desc == "" {
+		return "route;dur=" + dur
+	}
+	return "route;desc=" + strconv.Quote(desc) + ";dur=" + dur
+}
+
+// methodNotAllowed responds with 405 and an Allow header listing the allowed
+// methods for the request. It is the default MethodNotAllowedHandler.
+func methodNotAllowed(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Allow", strings.Join(AllowedMethods(req), ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+		http.StatusMethodNotAllowed)
+}
+
+// RecoverFunc is the function signature used to handle panics.
+// See Router.Recover().
+type RecoverFunc func(w http.ResponseWriter, req *http.Request,
+	recovered interface{})
+
+// Recover sets a function to handle panics from handlers dispatched by the
+// router, preventing them from crashing the server goroutine. The function
+// receives the value returned by recover().
+//
+// If f is nil, a default function is used, which responds with 500
+// (http.StatusInternalServerError).
+//
+// Request context is cleared after the function is called.
+func (r *Router) Recover(f RecoverFunc) *Router {
+	if f == nil {
+		f = internalServerError
+	}
+	r.recoverHandler = f
+	return r
+}
+
+// recover calls the recover handler if a handler panicked.
+func (r *Router) recover(w http.ResponseWriter, req *http.Request) {
+	if rv := recover(); rv != nil {
+		r.recoverHandler(w, req, rv)
+	}
+}
+
+// internalServerError is the default RecoverFunc.
+func internalServerError(w http.ResponseWriter, req *http.Request,
+	recovered interface{}) {
+	http.Error(w, http.StatusText(http.StatusInternalServerError),
+		http.StatusInternalServerError)
+}
+
 // Get returns a route registered with the given name.
 func (r *Router) Get(name string) *Route {
 	return r.getNamedRoutes()[name]
@@ -105,11 +313,197 @@ func (r *Router) GetRoute(name string) *Route {
 // Special case: when a route sets a path prefix, strict slash is
 // automatically set to false for that route because the redirect behavior
 // can't be determined for prefixes.
+//
+// Calling StrictSlash() resets the policy set by Router.SlashPolicy().
 func (r *Router) StrictSlash(value bool) *Router {
 	r.strictSlash = value
+	r.slashPolicy = SlashOff
+	return r
+}
+
+// SlashPolicy defines a canonical form for trailing slashes in new routes.
+//
+// Unlike StrictSlash(true), which redirects back and forth to the form
+// used in the route path, this always redirects in a single direction:
+//
+// - SlashOff doesn't redirect.
+//
+// - SlashAdd redirects "/path" to "/path/".
+//
+// - SlashRemove redirects "/path/" to "/path".
+//
+// Routes match both forms regardless of the slash used in the route path.
+// Like for StrictSlash(), the policy is not applied to path prefixes.
+//
+// Calling SlashPolicy() resets the value set by Router.StrictSlash().
+func (r *Router) SlashPolicy(policy SlashPolicy) *Router {
+	r.slashPolicy = policy
+	r.strictSlash = false
 	return r
 }
 
+// SetRegexpFlags defines regexp flags for the host and path patterns of new
+// routes, e.g. "i" for case-insensitive or "s" to let "." match newlines.
+// See the regexp/syntax package for all flags.
+//
+// The flags apply to all routes created after the call, including the
+// patterns used to validate variables when building URLs. Use with care:
+// flags change the meaning of every pattern, and a case-insensitive router
+// can match several URLs that are considered distinct elsewhere.
+func (r *Router) SetRegexpFlags(flags string) *Router {
+	r.regexpFlags = flags
+	return r
+}
+
+// RegisterVarConverter registers a named pattern for route variables.
+//
+// Templates can then use the name in place of a regexp, as in "{id:int}".
+// If validate is not nil, it is called with the value of the variable after
+// the route matches, as for Route.VarValidator(). For example:
+//
+//     r := mux.NewRouter()
+//     r.RegisterVarConverter("hex", "[0-9a-f]+", nil)
+//     r.HandleFunc("/colors/{color:hex}", ColorHandler)
+//
+// The built-in converters are "int" (an integer that fits in an int), "uuid"
+// and "slug" (lowercase words joined by hyphens). Registered converters
+// take precedence. They apply to routes and subrouters created after the
+// call.
+func (r *Router) RegisterVarConverter(name, pattern string,
+	validate func(string) bool) *Router {
+	convs := make(map[string]varConverter, len(r.varConverters)+1)
+	for k, v := range r.varConverters {
+		convs[k] = v
+	}
+	convs[name] = varConverter{pattern: pattern, validate: validate}
+	r.varConverters = convs
+	return r
+}
+
+// TrustForwardedFor defines if new routes use the X-Forwarded-For header to
+// get the client address. See Route.RemoteAddrIn().
+//
+// When true, the address added by the last proxy, the last one in the
+// header, is used instead of the request RemoteAddr. Only enable this when
+// the server runs behind a proxy that sets the header, otherwise clients
+// can choose their own address.
+func (r *Router) TrustForwardedFor(value bool) *Router {
+	r.trustForwardedFor = value
+	return r
+}
+
+// Freeze marks the router and its subrouters as read-only, after all routes
+// were registered.
+//
+// Registering a route or subrouter afterwards panics. This catches late
+// registrations, which are not safe while the router is serving requests.
+func (r *Router) Freeze() *Router {
+	r.frozen = true
+	for _, route := range r.routes {
+		for _, m := range route.matchers {
+			if sub, ok := m.(*Router); ok {
+				sub.Freeze()
+			}
+		}
+	}
+	return r
+}
+
+// Use adds middleware functions to wrap the handlers of matched routes.
+//
+// Middleware is applied in the order it was added, the first one being the
+// outermost, and the middleware of a router wraps the one of its
+// subrouters. It only runs when a route matches: to also wrap the
+// NotFoundHandler, set it wrapped by the middleware.
+func (r *Router) Use(mw ...func(http.Handler) http.Handler) *Router {
+	r.middlewares = append(r.middlewares, mw...)
+	return r
+}
+
+// SkipClean defines if the path cleaning is skipped.
+//
+// By default, requests for a path that is not in canonical form, e.g. with
+// "." or ".." elements or duplicate slashes, are redirected to the cleaned
+// path. When true, there's no redirect and routes are matched against the
+// raw path from the request URI, keeping encoded characters: "%2F" in a
+// path segment doesn't separate segments. Route variables hold the encoded
+// values, which can be decoded with url.PathUnescape().
+//
+// The application is then responsible for normalizing paths.
+func (r *Router) SkipClean(value bool) *Router {
+	r.skipClean = value
+	return r
+}
+
+// ServerTiming defines if the router adds a Server-Timing header with the
+// time spent matching the request, for performance diagnostics. The header
+// also names the matched route, using the route name or path template:
+//
+//     Server-Timing: route;desc="article";dur=0.012
+//
+// The duration is in milliseconds. Measuring it adds a small overhead to
+// each request, and the header exposes route names to clients, so this is
+// best enabled only when needed. Paths redirected to their canonical form
+// are not timed.
+func (r *Router) ServerTiming(value bool) *Router {
+	r.serverTiming = value
+	return r
+}
+
+// CollapseSlashes defines if duplicate slashes in the request path are
+// collapsed before matching.
+//
+// By default, a request for a path that is not in canonical form, like
+// "/a//b" or "/a/../b", is redirected to the cleaned path. Some clients
+// don't follow redirects well, particularly for methods other than GET.
+// When true, duplicate slashes are instead collapsed in place, without a
+// redirect, so "/a//b" matches a route for "/a/b" directly. Paths with "."
+// or ".." elements are still redirected.
+//
+// This only applies to the router serving the request, not subrouters.
+// See also Router.SkipClean().
+func (r *Router) CollapseSlashes(value bool) *Router {
+	r.collapseSlashes = value
+	return r
+}
+
+// FormatExtensions defines path extensions used to request a response
+// format, e.g. "json" or "xml".
+//
+// If the request path ends with one of the extensions, the extension is
+// removed before matching, and the format is available in the "format"
+// route variable. For example, with the "json" extension the route
+// "/users/{id}" matches "/users/42.json" with the variables id="42" and
+// format="json". A route variable also named "format" takes precedence. If
+// no route matches the path without the extension, the full path is matched
+// as usual.
+//
+// The path is cleaned before the extension is removed, so
+// "/users/../users/42.json" also matches. The request URL is not modified.
+//
+// Paths matched without the extension are not redirected by StrictSlash()
+// or SlashPolicy(): the extension ends the path, so "/users/42.json" matches
+// both "/users/{id}" and "/users/{id}/" without a redirect.
+func (r *Router) FormatExtensions(exts ...string) *Router {
+	r.formatExts = exts
+	return r
+}
+
+// stripFormat returns the path without a format extension, and the format.
+// It returns an empty format if the path doesn't end with an extension.
+func (r *Router) stripFormat(p string) (string, string) {
+	for _, ext := range r.formatExts {
+		if ext = strings.TrimPrefix(ext, "."); ext == "" {
+			continue
+		}
+		if strings.HasSuffix(p, "."+ext) && len(p) > len(ext)+1 &&
+			p[len(p)-len(ext)-2] != '/' {
+			return p[:len(p)-len(ext)-1], ext
+		}
+	}
+	return p, ""
+}
+
 // ----------------------------------------------------------------------------
 // parentRoute
 // ----------------------------------------------------------------------------
@@ -140,11 +534,40 @@ func (r *Router) getRegexpGroup() *routeRegexpGroup {
 
 // NewRoute registers an empty route.
 func (r *Router) NewRoute() *Route {
-	route := &Route{parent: r, strictSlash: r.strictSlash}
+	if r.frozen {
+		panic("mux: can't register a route, the router is frozen")
+	}
+	route := &Route{parent: r, strictSlash: r.strictSlash,
+		slashPolicy: r.slashPolicy, regexpFlags: r.regexpFlags,
+		varConverters: r.varConverters, trustForwardedFor: r.trustForwardedFor}
 	r.routes = append(r.routes, route)
 	return route
 }
 
+// Group registers a new route with a matcher for the URL path prefix and
+// calls fn with a subrouter for it, to register routes relative to the
+// prefix. It returns the router, for chaining. For example:
+//
+//     r := mux.NewRouter()
+//     r.Group("/api", func(s *mux.Router) {
+//         // "/api/users"
+//         s.HandleFunc("/users", UsersHandler)
+//         // "/api/posts"
+//         s.HandleFunc("/posts", PostsHandler)
+//     })
+//
+// See Route.PathPrefix() and Route.Subrouter().
+func (r *Router) Group(prefix string, fn func(*Router)) *Router {
+	fn(r.PathPrefix(prefix).Subrouter())
+	return r
+}
+
+// Resource returns a Resource to register handlers for HTTP methods on the
+// given URL path. See Resource.
+func (r *Router) Resource(path string) *Resource {
+	return &Resource{router: r, path: path}
+}
+
 // Handle registers a new route with a matcher for the URL path.
 // See Route.Path() and Route.Handler().
 func (r *Router) Handle(path string, handler http.Handler) *Route {
@@ -158,6 +581,57 @@ func (r *Router) HandleFunc(path string, f func(http.ResponseWriter,
 	return r.NewRoute().Path(path).HandlerFunc(f)
 }
 
+// Post registers a new route with matchers for the URL path and the POST
+// method. See Route.Path(), Route.Methods() and Route.Handler().
+//
+// There's no equivalent for GET because Router.Get() returns named routes.
+// Use r.Path(path).Methods("GET") or Router.Resource() instead.
+func (r *Router) Post(path string, handler http.Handler) *Route {
+	return r.handleMethod("POST", path, handler)
+}
+
+// Put registers a new route with matchers for the URL path and the PUT
+// method. See Router.Post().
+func (r *Router) Put(path string, handler http.Handler) *Route {
+	return r.handleMethod("PUT", path, handler)
+}
+
+// Delete registers a new route with matchers for the URL path and the DELETE
+// method. See Router.Post().
+func (r *Router) Delete(path string, handler http.Handler) *Route {
+	return r.handleMethod("DELETE", path, handler)
+}
+
+// Patch registers a new route with matchers for the URL path and the PATCH
+// method. See Router.Post().
+func (r *Router) Patch(path string, handler http.Handler) *Route {
+	return r.handleMethod("PATCH", path, handler)
+}
+
+// Head registers a new route with matchers for the URL path and the HEAD
+// method. See Router.Post().
+func (r *Router) Head(path string, handler http.Handler) *Route {
+	return r.handleMethod("HEAD", path, handler)
+}
+
+// Options registers a new route with matchers for the URL path and the
+// OPTIONS method. See Router.Post().
+func (r *Router) Options(path string, handler http.Handler) *Route {
+	return r.handleMethod("OPTIONS", path, handler)
+}
+
+// handleMethod registers a new route with matchers for the URL path and an
+// HTTP method.
+func (r *Router) handleMethod(method, path string, handler http.Handler) *Route {
+	return r.NewRoute().Path(path).Methods(method).Handler(handler)
+}
+
+// BodyContentType registers a new route with a matcher for the media type
+// of the request body. See Route.BodyContentType().
+func (r *Router) BodyContentType(types ...string) *Route {
+	return r.NewRoute().BodyContentType(types...)
+}
+
 // Headers registers a new route with a matcher for request header values.
 // See Route.Headers().
 func (r *Router) Headers(pairs ...string) *Route {
@@ -194,6 +668,18 @@ func (r *Router) PathPrefix(tpl string) *Route {
 	return r.NewRoute().PathPrefix(tpl)
 }
 
+// Port registers a new route with a matcher for the URL port.
+// See Route.Port().
+func (r *Router) Port(tpl string) *Route {
+	return r.NewRoute().Port(tpl)
+}
+
+// RemoteAddrIn registers a new route with a matcher for the client address.
+// See Route.RemoteAddrIn().
+func (r *Router) RemoteAddrIn(cidrs ...string) *Route {
+	return r.NewRoute().RemoteAddrIn(cidrs...)
+}
+
 // Queries registers a new route with a matcher for URL query values.
 // See Route.Queries().
 func (r *Router) Queries(pairs ...string) *Route {
@@ -206,6 +692,75 @@ func (r *Router) Schemes(schemes ...string) *Route {
 	return r.NewRoute().Schemes(schemes...)
 }
 
+// ----------------------------------------------------------------------------
+// Resource
+// ----------------------------------------------------------------------------
+
+// Resource registers handlers for HTTP methods on a URL path, defined once.
+// Each handler can be wrapped by middleware for its method only. For example,
+// to share a handler for GET and POST, but check CSRF tokens only for POST:
+//
+//     r := mux.NewRouter()
+//     res := r.Resource("/articles/{id}")
+//     res.Get(ArticleHandler)
+//     res.Post(ArticleHandler, CSRFMiddleware)
+//
+// Each method registers a new route, which is returned to set other
+// matchers or a name.
+type Resource struct {
+	router *Router
+	path   string
+}
+
+// Handle registers a route for the resource path and the given HTTP method.
+//
+// The handler is wrapped by the middleware functions, the first one being
+// the outermost.
+func (r *Resource) Handle(method string, handler http.Handler,
+	mw ...func(http.Handler) http.Handler) *Route {
+	return r.router.NewRoute().Path(r.path).Methods(method).
+		Handler(chain(handler, mw))
+}
+
+// Get registers a route for GET requests. See Resource.Handle().
+func (r *Resource) Get(handler http.Handler,
+	mw ...func(http.Handler) http.Handler) *Route {
+	return r.Handle("GET", handler, mw...)
+}
+
+// Post registers a route for POST requests. See Resource.Handle().
+func (r *Resource) Post(handler http.Handler,
+	mw ...func(http.Handler) http.Handler) *Route {
+	return r.Handle("POST", handler, mw...)
+}
+
+// Put registers a route for PUT requests. See Resource.Handle().
+func (r *Resource) Put(handler http.Handler,
+	mw ...func(http.Handler) http.Handler) *Route {
+	return r.Handle("PUT", handler, mw...)
+}
+
+// Patch registers a route for PATCH requests. See Resource.Handle().
+func (r *Resource) Patch(handler http.Handler,
+	mw ...func(http.Handler) http.Handler) *Route {
+	return r.Handle("PATCH", handler, mw...)
+}
+
+// Delete registers a route for DELETE requests. See Resource.Handle().
+func (r *Resource) Delete(handler http.Handler,
+	mw ...func(http.Handler) http.Handler) *Route {
+	return r.Handle("DELETE", handler, mw...)
+}
+
+// chain wraps a handler with middleware functions, the first one being the
+// outermost.
+func chain(handler http.Handler, mw []func(http.Handler) http.Handler) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		handler = mw[i](handler)
+	}
+	return handler
+}
+
 // ----------------------------------------------------------------------------
 // Context
 // ----------------------------------------------------------------------------
@@ -215,6 +770,25 @@ type RouteMatch struct {
 	Route   *Route
 	Handler http.Handler
 	Vars    map[string]string
+	// MatchErr is set to ErrMethodMismatch if no route matched, but one
+	// matched the request except for the HTTP method.
+	MatchErr error
+	// Methods allowed by the routes that only failed to match the method.
+	allowedMethods []string
+	// Handler for a method mismatch, from the innermost router defining it.
+	methodNotAllowed http.Handler
+	// Format extension removed from the path for matching, if any.
+	format string
+	// Request URL before the path was changed for matching, if it was.
+	// Redirects are built from it.
+	origURL *url.URL
+}
+
+// restore sets the route, handler and variables saved from a match.
+func (m *RouteMatch) restore(saved *RouteMatch) {
+	m.Route = saved.Route
+	m.Handler = saved.Handler
+	m.Vars = saved.Vars
 }
 
 type contextKey int
@@ -222,9 +796,13 @@ type contextKey int
 const (
 	varsKey contextKey = iota
 	routeKey
+	allowedMethodsKey
 )
 
 // Vars returns the route variables for the current request, if any.
+//
+// For routes without variables it returns a nil map, which can be read
+// as an empty map.
 func Vars(r *http.Request) map[string]string {
 	if rv := context.Get(r, varsKey); rv != nil {
 		return rv.(map[string]string)
@@ -232,6 +810,104 @@ func Vars(r *http.Request) map[string]string {
 	return nil
 }
 
+// Var returns a route variable for the current request, or an empty string
+// if it is not set.
+func Var(r *http.Request, name string) string {
+	return Vars(r)[name]
+}
+
+// VarInt returns a route variable for the current request converted to int.
+// It returns an error if the variable is not set or can't be converted.
+func VarInt(r *http.Request, name string) (int, error) {
+	v, err := varInt(r, name, 0)
+	return int(v), err
+}
+
+// VarInt64 returns a route variable for the current request converted to
+// int64. It returns an error if the variable is not set or can't be
+// converted.
+func VarInt64(r *http.Request, name string) (int64, error) {
+	return varInt(r, name, 64)
+}
+
+// VarUint returns a route variable for the current request converted to
+// uint. It returns an error if the variable is not set or can't be
+// converted.
+func VarUint(r *http.Request, name string) (uint, error) {
+	value, ok := Vars(r)[name]
+	if !ok {
+		return 0, fmt.Errorf("mux: missing route variable %q", name)
+	}
+	v, err := strconv.ParseUint(value, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("mux: route variable %q is not an unsigned "+
+			"integer: %q", name, value)
+	}
+	return uint(v), nil
+}
+
+// varInt returns a route variable converted to an int of the given size.
+func varInt(r *http.Request, name string, bitSize int) (int64, error) {
+	value, ok := Vars(r)[name]
+	if !ok {
+		return 0, fmt.Errorf("mux: missing route variable %q", name)
+	}
+	v, err := strconv.ParseInt(value, 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("mux: route variable %q is not an integer: %q",
+			name, value)
+	}
+	return v, nil
+}
+
+// varsDecoder is the decoder used by DecodeVars().
+var varsDecoder = newVarsDecoder()
+
+// newVarsDecoder returns a schema decoder that ignores variables without a
+// matching field.
+func newVarsDecoder() *schema.Decoder {
+	d := schema.NewDecoder()
+	d.IgnoreUnknownKeys(true)
+	return d
+}
+
+// DecodeVars decodes the route variables for the current request into a
+// struct, using a decoder from the gorilla/schema package. Field names or
+// schema tags are matched against variable names, and values are converted
+// to the field types:
+//
+//     type PostParams struct {
+//         UserID int    `schema:"id"`
+//         Slug   string `schema:"slug"`
+//     }
+//
+//     // For a route with the path "/users/{id}/posts/{slug}":
+//     var params PostParams
+//     err := mux.DecodeVars(r, &params)
+//
+// The first parameter must be a pointer to a struct. Variables without a
+// matching field are ignored, like the "format" variable set for
+// Router.FormatExtensions(). Custom types can be decoded implementing
+// encoding.TextUnmarshaler; converters registered in the schema package
+// are not used. Errors are returned as by schema.Decode().
+func DecodeVars(r *http.Request, dst interface{}) error {
+	vars := Vars(r)
+	src := make(map[string][]string, len(vars))
+	for k, v := range vars {
+		src[k] = []string{v}
+	}
+	return varsDecoder.Decode(dst, src)
+}
+
+// AllowedMethods returns the HTTP methods allowed for the current request,
+// when it is dispatched to a Router.MethodNotAllowedHandler.
+func AllowedMethods(r *http.Request) []string {
+	if rv := context.Get(r, allowedMethodsKey); rv != nil {
+		return rv.([]string)
+	}
+	return nil
+}
+
 // CurrentRoute returns the matched route for the current request, if any.
 func CurrentRoute(r *http.Request) *Route {
 	if rv := context.Get(r, routeKey); rv != nil {
@@ -240,6 +916,15 @@ func CurrentRoute(r *http.Request) *Route {
 	return nil
 }
 
+// RouteMetadata returns a value stored with Route.Metadata() in the matched
+// route for the current request, and true if it was found.
+func RouteMetadata(r *http.Request, key interface{}) (interface{}, bool) {
+	if route := CurrentRoute(r); route != nil {
+		return route.GetMetadata(key)
+	}
+	return nil, false
+}
+
 func setVars(r *http.Request, val interface{}) {
 	context.Set(r, varsKey, val)
 }
@@ -248,10 +933,131 @@ func setCurrentRoute(r *http.Request, val interface{}) {
 	context.Set(r, routeKey, val)
 }
 
+func setAllowedMethods(r *http.Request, methods []string) {
+	var unique []string
+	for _, v := range methods {
+		if !matchInArray(unique, v) {
+			unique = append(unique, v)
+		}
+	}
+	context.Set(r, allowedMethodsKey, unique)
+}
+
+// ----------------------------------------------------------------------------
+// Redirects
+// ----------------------------------------------------------------------------
+
+// CanonicalHost returns a handler that redirects requests to the given host,
+// preserving scheme, path and query.
+//
+// If code is 0, 301 (http.StatusMovedPermanently) is used. For example, to
+// redirect the bare domain to "www":
+//
+//     r := mux.NewRouter()
+//     r.Host("domain.com").Handler(mux.CanonicalHost("www.domain.com", 301))
+func CanonicalHost(host string, code int) http.Handler {
+	return canonicalHandler(&CanonicalOptions{Host: host, Code: code})
+}
+
+// RedirectToHTTPS returns a handler that redirects requests to the https
+// scheme, preserving host, path and query.
+//
+// If code is 0, 301 (http.StatusMovedPermanently) is used.
+func RedirectToHTTPS(code int) http.Handler {
+	return canonicalHandler(&CanonicalOptions{Scheme: "https", Code: code})
+}
+
+// canonicalHandler returns a handler that always redirects to the canonical
+// URL for the request.
+func canonicalHandler(o *CanonicalOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		scheme, host := getScheme(req), getHost(req)
+		if o.Scheme != "" {
+			scheme = o.Scheme
+		}
+		if o.Host != "" {
+			host = o.Host
+		}
+		http.Redirect(w, req, canonicalURL(req, scheme, host), o.code())
+	})
+}
+
+// ----------------------------------------------------------------------------
+// Static files
+// ----------------------------------------------------------------------------
+
+// SPAHandler returns a handler for single-page applications. It serves files
+// from staticDir when they exist, and indexFile, relative to staticDir, for
+// any other path, so that client-side routes can be handled by the app.
+//
+// Paths containing ".." elements are rejected with 400 (http.StatusBadRequest).
+// Register it as the last route, after API routes:
+//
+//     r := mux.NewRouter()
+//     r.PathPrefix("/api/").Handler(apiHandler)
+//     r.PathPrefix("/").Handler(mux.SPAHandler("static", "index.html"))
+func SPAHandler(staticDir string, indexFile string) http.Handler {
+	return &spaHandler{root: http.Dir(staticDir), index: "/" + indexFile}
+}
+
+// spaHandler serves static files with a fallback to an index file.
+type spaHandler struct {
+	root  http.FileSystem
+	index string
+}
+
+func (h *spaHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if containsDotDot(req.URL.Path) {
+		http.Error(w, "invalid URL path", http.StatusBadRequest)
+		return
+	}
+	if h.serveFile(w, req, path.Clean("/"+req.URL.Path)) {
+		return
+	}
+	if !h.serveFile(w, req, h.index) {
+		http.NotFound(w, req)
+	}
+}
+
+// serveFile serves the named file if it exists and is not a directory.
+func (h *spaHandler) serveFile(w http.ResponseWriter, req *http.Request,
+	name string) bool {
+	f, err := h.root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	d, err := f.Stat()
+	if err != nil || d.IsDir() {
+		return false
+	}
+	http.ServeContent(w, req, d.Name(), d.ModTime(), f)
+	return true
+}
+
 // ----------------------------------------------------------------------------
 // Helpers
 // ----------------------------------------------------------------------------
 
+// getScheme tries its best to return the request scheme.
+func getScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return r.URL.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// canonicalURL returns the request URL using the given scheme and host.
+func canonicalURL(r *http.Request, scheme, host string) string {
+	u := *r.URL
+	u.Scheme = scheme
+	u.Host = host
+	return u.String()
+}
+
 // cleanPath returns the canonical path for p, eliminating . and .. elements.
 // Borrowed from the net/http package.
 func cleanPath(p string) string {
@@ -270,6 +1076,32 @@ func cleanPath(p string) string {
 	return np
 }
 
+// containsDotDot returns true if p has a ".." path element.
+func containsDotDot(p string) bool {
+	for _, e := range strings.FieldsFunc(p, func(r rune) bool {
+		return r == '/' || r == '\\'
+	}) {
+		if e == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// collapseSlashes replaces sequences of slashes in p by a single slash.
+func collapseSlashes(p string) string {
+	if !strings.Contains(p, "//") {
+		return p
+	}
+	b := make([]byte, 0, len(p))
+	for i := 0; i < len(p); i++ {
+		if p[i] != '/' || i == 0 || p[i-1] != '/' {
+			b = append(b, p[i])
+		}
+	}
+	return string(b)
+}
+
 // uniqueVars returns an error if two slices contain duplicated strings.
 func uniqueVars(s1, s2 []string) error {
 	for _, v1 := range s1 {
@@ -5,9 +5,19 @@
 package mux
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"code.google.com/p/gorilla/context"
 )
 
 func TestRoute(t *testing.T) {
@@ -402,10 +412,11 @@ func mapToPairs(m map[string]string) []string {
 	return p
 }
 
+// stringMapEqual returns true if both maps have the same key/value pairs.
+// A nil map is equal to an empty map: routes without variables don't
+// allocate them.
 func stringMapEqual(m1, m2 map[string]string) bool {
-	nil1 := m1 == nil
-	nil2 := m2 == nil
-	if nil1 != nil2 || len(m1) != len(m2) {
+	if len(m1) != len(m2) {
 		return false
 	}
 	for k, v := range m1 {
@@ -415,3 +426,1596 @@ func stringMapEqual(m1, m2 map[string]string) bool {
 	}
 	return true
 }
+
+func TestCanonical(t *testing.T) {
+	r := NewRouter()
+	r.PathPrefix("/").Canonical(CanonicalOptions{
+		Host:   "www.domain.com",
+		Scheme: "https",
+	}).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(200)
+	})
+
+	tests := []struct {
+		url      string
+		code     int
+		location string
+	}{
+		{"http://domain.com/foo/bar?baz=ding", 301, "https://www.domain.com/foo/bar?baz=ding"},
+		{"https://domain.com/foo", 301, "https://www.domain.com/foo"},
+		{"http://www.domain.com/foo?baz=ding", 301, "https://www.domain.com/foo?baz=ding"},
+		{"https://www.domain.com/foo", 200, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != test.code {
+			t.Errorf("%s: expected code %d, got %d", test.url, test.code, res.Code)
+		}
+		if loc := res.HeaderMap.Get("Location"); loc != test.location {
+			t.Errorf("%s: expected location %q, got %q", test.url, test.location, loc)
+		}
+	}
+}
+
+func TestCanonicalHandlers(t *testing.T) {
+	r := NewRouter()
+	r.Host("domain.com").Handler(CanonicalHost("www.domain.com", 0))
+	r.Schemes("http").Handler(RedirectToHTTPS(http.StatusPermanentRedirect))
+
+	req, _ := http.NewRequest("GET", "http://domain.com/foo?bar=baz", nil)
+	res := NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != 301 {
+		t.Errorf("Expected code 301, got %d", res.Code)
+	}
+	if loc, exp := res.HeaderMap.Get("Location"), "http://www.domain.com/foo?bar=baz"; loc != exp {
+		t.Errorf("Expected location %q, got %q", exp, loc)
+	}
+
+	req, _ = http.NewRequest("GET", "http://www.domain.com/foo?bar=baz", nil)
+	res = NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != 308 {
+		t.Errorf("Expected code 308, got %d", res.Code)
+	}
+	if loc, exp := res.HeaderMap.Get("Location"), "https://www.domain.com/foo?bar=baz"; loc != exp {
+		t.Errorf("Expected location %q, got %q", exp, loc)
+	}
+}
+
+func TestBodyContentType(t *testing.T) {
+	route := new(Route).BodyContentType("application/json", "text/xml")
+	tests := []struct {
+		contentType string
+		shouldMatch bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"Text/XML; charset=utf-8", true},
+		{"application/x-www-form-urlencoded", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "http://localhost/", nil)
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		if matched := route.Match(req, new(RouteMatch)); matched != test.shouldMatch {
+			t.Errorf("%q: expected match %v, got %v", test.contentType, test.shouldMatch, matched)
+		}
+	}
+}
+
+func TestRegexpFlags(t *testing.T) {
+	r := NewRouter()
+	route := r.Path("/Foo/{bar:[a-z]+}")
+	req, _ := http.NewRequest("GET", "http://localhost/foo/BAR", nil)
+	if route.Match(req, new(RouteMatch)) {
+		t.Errorf("Should not match request %q without flags", req.URL.Path)
+	}
+
+	r = NewRouter().SetRegexpFlags("i")
+	route = r.Path("/Foo/{bar:[a-z]+}")
+	match := new(RouteMatch)
+	if !route.Match(req, match) {
+		t.Errorf("Should match request %q with the \"i\" flag", req.URL.Path)
+	} else if match.Vars["bar"] != "BAR" {
+		t.Errorf("Expected var %q, got %q", "BAR", match.Vars["bar"])
+	}
+
+	// Flags are inherited by subrouters.
+	s := r.PathPrefix("/sub").Subrouter()
+	route = s.Path("/baz")
+	req, _ = http.NewRequest("GET", "http://localhost/SUB/BAZ", nil)
+	if !route.Match(req, new(RouteMatch)) {
+		t.Errorf("Should match request %q in subrouter", req.URL.Path)
+	}
+}
+
+func TestSlashPolicy(t *testing.T) {
+	tests := []struct {
+		policy   SlashPolicy
+		tpl      string
+		path     string
+		location string
+	}{
+		{SlashOff, "/foo", "/foo", ""},
+		{SlashOff, "/foo/", "/foo/", ""},
+		{SlashAdd, "/foo", "/foo", "http://localhost/foo/"},
+		{SlashAdd, "/foo", "/foo/", ""},
+		{SlashAdd, "/foo/", "/foo", "http://localhost/foo/"},
+		{SlashAdd, "/foo/", "/foo/", ""},
+		{SlashRemove, "/foo", "/foo", ""},
+		{SlashRemove, "/foo", "/foo/", "http://localhost/foo"},
+		{SlashRemove, "/foo/", "/foo", ""},
+		{SlashRemove, "/foo/", "/foo/?bar=baz", "http://localhost/foo?bar=baz"},
+		{SlashRemove, "/", "/", ""},
+	}
+	for _, test := range tests {
+		r := NewRouter().SlashPolicy(test.policy)
+		route := r.Path(test.tpl)
+		req, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		match := new(RouteMatch)
+		if !r.Match(req, match) {
+			t.Errorf("%v %q: should match request %q", test.policy, test.tpl, test.path)
+			continue
+		}
+		if match.Route != route {
+			t.Errorf("%v %q: matched the wrong route", test.policy, test.tpl)
+		}
+		location := ""
+		if match.Handler != nil {
+			res := NewRecorder()
+			match.Handler.ServeHTTP(res, req)
+			location = res.HeaderMap.Get("Location")
+		}
+		if location != test.location {
+			t.Errorf("%v %q: expected redirect to %q for %q, got %q", test.policy, test.tpl, test.location, test.path, location)
+		}
+	}
+}
+
+func TestRouteErrors(t *testing.T) {
+	route := new(Route).Path("foo").Headers("a").Host("{bar")
+	errs := route.GetErrors()
+	if len(errs) != 3 {
+		t.Fatalf("Expected 3 errors, got %v", errs)
+	}
+	err, ok := route.GetError().(MultiError)
+	if !ok {
+		t.Fatalf("Expected a MultiError, got %#v", route.GetError())
+	}
+	if len(err) != 3 {
+		t.Errorf("Expected 3 errors in MultiError, got %v", err)
+	}
+
+	// Builders after an error still add their matchers.
+	route = new(Route).Path("foo").Methods("GET").Schemes("https").
+		Headers("X-Foo", "bar")
+	if len(route.matchers) != 3 {
+		t.Errorf("Expected 3 matchers after an error, got %v", route.matchers)
+	}
+
+	// A single error is returned as is.
+	route = new(Route).Path("foo")
+	if _, ok := route.GetError().(MultiError); ok || route.GetError() == nil {
+		t.Errorf("Expected a single error, got %#v", route.GetError())
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	modified := time.Date(2012, 10, 3, 12, 0, 0, 0, time.UTC)
+	r := NewRouter()
+	r.HandleFunc("/{id}", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("content"))
+	}).Cacheable(func(req *http.Request) string {
+		return "v-" + Vars(req)["id"]
+	}, func(req *http.Request) time.Time {
+		return modified
+	})
+
+	tests := []struct {
+		header string
+		value  string
+		code   int
+	}{
+		{"", "", 200},
+		{"If-None-Match", `"v-1"`, 304},
+		{"If-None-Match", `"v-2", "v-1"`, 304},
+		{"If-None-Match", `W/"v-1"`, 304},
+		{"If-None-Match", `*`, 304},
+		{"If-None-Match", `"v-2"`, 200},
+		{"If-Modified-Since", modified.Format(http.TimeFormat), 304},
+		{"If-Modified-Since", modified.Add(time.Hour).Format(http.TimeFormat), 304},
+		{"If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), 200},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost/1", nil)
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != test.code {
+			t.Errorf("%s %s: expected code %d, got %d", test.header, test.value, test.code, res.Code)
+		}
+		if test.code == 200 && res.Body.String() != "content" {
+			t.Errorf("%s %s: expected the handler to run", test.header, test.value)
+		}
+		if test.code == 304 && res.Body.Len() != 0 {
+			t.Errorf("%s %s: expected an empty body", test.header, test.value)
+		}
+		if etag := res.HeaderMap.Get("ETag"); etag != `"v-1"` {
+			t.Errorf("Expected ETag %q, got %q", `"v-1"`, etag)
+		}
+		if lm, exp := res.HeaderMap.Get("Last-Modified"), modified.Format(http.TimeFormat); lm != exp {
+			t.Errorf("Expected Last-Modified %q, got %q", exp, lm)
+		}
+	}
+}
+
+func TestTypedVars(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	setVars(req, map[string]string{"id": "42", "neg": "-1", "name": "foo"})
+	defer context.Clear(req)
+
+	if v := Var(req, "name"); v != "foo" {
+		t.Errorf("Var: expected %q, got %q", "foo", v)
+	}
+	if v := Var(req, "missing"); v != "" {
+		t.Errorf("Var: expected empty string, got %q", v)
+	}
+	if v, err := VarInt(req, "id"); v != 42 || err != nil {
+		t.Errorf("VarInt: expected 42, got %v, %v", v, err)
+	}
+	if v, err := VarInt64(req, "neg"); v != -1 || err != nil {
+		t.Errorf("VarInt64: expected -1, got %v, %v", v, err)
+	}
+	if v, err := VarUint(req, "id"); v != 42 || err != nil {
+		t.Errorf("VarUint: expected 42, got %v, %v", v, err)
+	}
+	if _, err := VarUint(req, "neg"); err == nil {
+		t.Errorf("VarUint: expected error for a negative value")
+	}
+	if _, err := VarInt(req, "name"); err == nil {
+		t.Errorf("VarInt: expected error for a non-integer value")
+	}
+	if _, err := VarInt64(req, "missing"); err == nil {
+		t.Errorf("VarInt64: expected error for a missing variable")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var recovered interface{}
+	var vars map[string]string
+	var req *http.Request
+	r := NewRouter()
+	r.HandleFunc("/{id}", func(w http.ResponseWriter, req *http.Request) {
+		panic("oops")
+	})
+
+	// Default handler.
+	r.Recover(nil)
+	req, _ = http.NewRequest("GET", "http://localhost/1", nil)
+	res := NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != 500 {
+		t.Errorf("Expected code 500, got %d", res.Code)
+	}
+
+	// Custom handler.
+	r.Recover(func(w http.ResponseWriter, req *http.Request, rv interface{}) {
+		recovered = rv
+		vars = Vars(req)
+		w.WriteHeader(500)
+	})
+	req, _ = http.NewRequest("GET", "http://localhost/2", nil)
+	res = NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != 500 {
+		t.Errorf("Expected code 500, got %d", res.Code)
+	}
+	if recovered != "oops" {
+		t.Errorf("Expected recovered value %q, got %v", "oops", recovered)
+	}
+	if vars["id"] != "2" {
+		t.Errorf("Expected route variables in the recover handler, got %v", vars)
+	}
+	if Vars(req) != nil {
+		t.Errorf("Expected context to be cleared, got %v", Vars(req))
+	}
+}
+
+func TestMethodsWithOptions(t *testing.T) {
+	r := NewRouter()
+	r.HandleFunc("/products", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Method))
+	}).MethodsWithOptions("get", "POST")
+
+	for _, method := range []string{"GET", "POST"} {
+		req, _ := http.NewRequest(method, "http://localhost/products", nil)
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != 200 || res.Body.String() != method {
+			t.Errorf("%s: expected the handler to run, got %d %q", method, res.Code, res.Body.String())
+		}
+	}
+
+	req, _ := http.NewRequest("OPTIONS", "http://localhost/products", nil)
+	res := NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != 204 {
+		t.Errorf("OPTIONS: expected code 204, got %d", res.Code)
+	}
+	if allow := res.HeaderMap.Get("Allow"); allow != "GET, POST, OPTIONS" {
+		t.Errorf("OPTIONS: expected Allow %q, got %q", "GET, POST, OPTIONS", allow)
+	}
+
+	req, _ = http.NewRequest("PUT", "http://localhost/products", nil)
+	res = NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != 405 {
+		t.Errorf("PUT: expected code 405, got %d", res.Code)
+	}
+	if allow := res.HeaderMap.Get("Allow"); allow != "GET, POST, OPTIONS" {
+		t.Errorf("PUT: expected Allow %q, got %q", "GET, POST, OPTIONS", allow)
+	}
+
+	// An explicit OPTIONS method is sent to the route handler.
+	r.HandleFunc("/orders", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Method))
+	}).MethodsWithOptions("GET", "options")
+	req, _ = http.NewRequest("OPTIONS", "http://localhost/orders", nil)
+	res = NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != 200 || res.Body.String() != "OPTIONS" {
+		t.Errorf("OPTIONS: expected the handler to run, got %d %q", res.Code, res.Body.String())
+	}
+}
+
+func TestFormatExtensions(t *testing.T) {
+	r := NewRouter().FormatExtensions("json", ".xml")
+	route := r.Path("/users/{id}")
+	static := r.Path("/static/app.json")
+	reports := r.Path("/reports/{format}")
+
+	tests := []struct {
+		path  string
+		route *Route
+		vars  map[string]string
+	}{
+		{"/reports/pdf.json", reports, map[string]string{"format": "pdf"}},
+		{"/users/42.json", route, map[string]string{"id": "42", "format": "json"}},
+		{"/users/42.xml", route, map[string]string{"id": "42", "format": "xml"}},
+		{"/users/42", route, map[string]string{"id": "42"}},
+		{"/users/42.txt", route, map[string]string{"id": "42.txt"}},
+		{"/static/app.json", static, map[string]string{}},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		match := new(RouteMatch)
+		if !r.Match(req, match) {
+			t.Errorf("%s: should match", test.path)
+			continue
+		}
+		if match.Route != test.route {
+			t.Errorf("%s: matched the wrong route", test.path)
+		}
+		if !stringMapEqual(match.Vars, test.vars) {
+			t.Errorf("%s: expected vars %v, got %v", test.path, test.vars, match.Vars)
+		}
+		if req.URL.Path != test.path {
+			t.Errorf("%s: request path was modified to %q", test.path, req.URL.Path)
+		}
+	}
+
+	// A method mismatch for the path without extension is not reported
+	// if the full path doesn't match.
+	r = NewRouter().FormatExtensions("json")
+	r.HandleFunc("/upload/{name:[a-z]+}", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(200)
+	}).Methods("POST")
+	req, _ := http.NewRequest("GET", "http://localhost/upload/a.json", nil)
+	res := NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != 404 {
+		t.Errorf("Expected status 404, got %d", res.Code)
+	}
+
+	// Paths with an extension are not redirected for trailing slashes.
+	for _, r := range []*Router{
+		NewRouter().FormatExtensions("json").StrictSlash(true),
+		NewRouter().FormatExtensions("json").SlashPolicy(SlashAdd),
+	} {
+		var vars map[string]string
+		r.HandleFunc("/users/{id}/", func(w http.ResponseWriter, req *http.Request) {
+			vars = Vars(req)
+			w.WriteHeader(200)
+		})
+		req, _ := http.NewRequest("GET", "http://localhost/users/42.json", nil)
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != 200 || vars["id"] != "42" || vars["format"] != "json" {
+			t.Errorf("Expected a match with vars, got status %d, vars %v, Location %q",
+				res.Code, vars, res.HeaderMap.Get("Location"))
+		}
+		// Paths without extension are still redirected.
+		req, _ = http.NewRequest("GET", "http://localhost/users/42", nil)
+		res = NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != 301 || res.HeaderMap.Get("Location") != "http://localhost/users/42/" {
+			t.Errorf("Expected a redirect to /users/42/, got %d %q", res.Code,
+				res.HeaderMap.Get("Location"))
+		}
+	}
+}
+
+func TestNoVars(t *testing.T) {
+	var vars map[string]string
+	r := NewRouter()
+	r.HandleFunc("/foo", func(w http.ResponseWriter, req *http.Request) {
+		vars = Vars(req)
+	})
+	req, _ := http.NewRequest("GET", "http://localhost/foo", nil)
+	match := new(RouteMatch)
+	if !r.Match(req, match) {
+		t.Fatalf("Should match request %q", req.URL.Path)
+	}
+	if match.Vars != nil {
+		t.Errorf("Expected nil vars, got %v", match.Vars)
+	}
+	vars = map[string]string{"not": "set"}
+	r.ServeHTTP(NewRecorder(), req)
+	if vars != nil || vars["foo"] != "" {
+		t.Errorf("Expected nil vars, got %v", vars)
+	}
+}
+
+func TestXHR(t *testing.T) {
+	route := new(Route).Path("/partial").XHR()
+	tests := []struct {
+		value       string
+		shouldMatch bool
+	}{
+		{"XMLHttpRequest", true},
+		{"xmlhttprequest", true},
+		{"", false},
+		{"Fetch", false},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost/partial", nil)
+		if test.value != "" {
+			req.Header.Set("X-Requested-With", test.value)
+		}
+		if matched := route.Match(req, new(RouteMatch)); matched != test.shouldMatch {
+			t.Errorf("%q: expected match %v, got %v", test.value, test.shouldMatch, matched)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	r := NewRouter()
+	r.Group("/api", func(s *Router) {
+		s.HandleFunc("/users", nil).Name("users")
+		s.HandleFunc("/posts/{id}", nil).Name("post")
+	}).HandleFunc("/users", nil).Name("root")
+
+	tests := []struct {
+		path string
+		name string
+	}{
+		{"/api/users", "users"},
+		{"/api/posts/42", "post"},
+		{"/users", "root"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		match := new(RouteMatch)
+		if !r.Match(req, match) {
+			t.Errorf("%s: should match", test.path)
+		} else if match.Route.GetName() != test.name {
+			t.Errorf("%s: expected route %q, got %q", test.path, test.name, match.Route.GetName())
+		}
+	}
+
+	u, err := r.Get("post").URL("id", "42")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if u.Path != "/api/posts/42" {
+		t.Errorf("Expected URL %q, got %q", "/api/posts/42", u.Path)
+	}
+}
+
+func TestRemoteAddrIn(t *testing.T) {
+	tests := []struct {
+		trust       bool
+		remoteAddr  string
+		forwarded   string
+		shouldMatch bool
+	}{
+		{false, "10.1.2.3:1234", "", true},
+		{false, "192.168.1.1:1234", "", true},
+		{false, "[::1]:1234", "", true},
+		{false, "8.8.8.8:1234", "", false},
+		{false, "8.8.8.8:1234", "10.1.2.3", false},
+		{false, "10.1.2.3:1234", "8.8.8.8", true},
+		{true, "10.1.2.3:1234", "8.8.8.8", false},
+		{true, "8.8.8.8:1234", "1.1.1.1, 10.1.2.3", true},
+		{true, "8.8.8.8:1234", "10.1.2.3, 1.1.1.1", false},
+		{true, "10.1.2.3:1234", "", true},
+	}
+	for _, test := range tests {
+		r := NewRouter().TrustForwardedFor(test.trust)
+		route := r.RemoteAddrIn("10.0.0.0/8", "192.168.0.0/16", "::1/128")
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if matched := route.Match(req, new(RouteMatch)); matched != test.shouldMatch {
+			t.Errorf("%v %q %q: expected match %v, got %v", test.trust, test.remoteAddr, test.forwarded, test.shouldMatch, matched)
+		}
+	}
+
+	route := new(Route).RemoteAddrIn("10.0.0.0/8", "10.0.0.0/33")
+	if route.GetError() == nil {
+		t.Errorf("Expected error for an invalid network")
+	}
+}
+
+func TestMethodNotAllowedHandler(t *testing.T) {
+	var allowed []string
+	r := NewRouter()
+	r.HandleFunc("/foo", nil).Methods("GET")
+	api := r.PathPrefix("/api").Subrouter()
+	api.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		allowed = AllowedMethods(req)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	api.HandleFunc("/users", nil).Methods("GET", "POST")
+	api.HandleFunc("/users", nil).Methods("PUT", "POST")
+	api.HandleFunc("/users/{id}", nil).Methods("GET")
+
+	tests := []struct {
+		method  string
+		path    string
+		code    int
+		allowed []string
+	}{
+		{"DELETE", "/api/users", 405, []string{"GET", "POST", "PUT"}},
+		{"POST", "/api/users/1", 405, []string{"GET"}},
+		{"POST", "/foo", 405, nil},
+		{"GET", "/api/posts", 404, nil},
+	}
+	for _, test := range tests {
+		allowed = nil
+		req, _ := http.NewRequest(test.method, "http://localhost"+test.path, nil)
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != test.code {
+			t.Errorf("%s %s: expected code %d, got %d", test.method, test.path, test.code, res.Code)
+		}
+		if fmt.Sprint(allowed) != fmt.Sprint(test.allowed) {
+			t.Errorf("%s %s: expected allowed methods %v, got %v", test.method, test.path, test.allowed, allowed)
+		}
+	}
+
+	// A later route matching the method is not affected.
+	r.HandleFunc("/api/users", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}).Methods("DELETE")
+	req, _ := http.NewRequest("DELETE", "http://localhost/api/users", nil)
+	res := NewRecorder()
+	r.ServeHTTP(res, req)
+	if res.Code != http.StatusAccepted {
+		t.Errorf("Expected code %d, got %d", http.StatusAccepted, res.Code)
+	}
+}
+
+func TestVarValidator(t *testing.T) {
+	validDate := func(s string) bool {
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	}
+	r := NewRouter()
+	archive := r.Path("/archive/{date:[0-9]{4}-[0-9]{2}-[0-9]{2}}").
+		VarValidator("date", validDate)
+	fallback := r.PathPrefix("/archive/")
+
+	tests := []struct {
+		path  string
+		route *Route
+	}{
+		{"/archive/2012-02-29", archive},
+		{"/archive/2012-02-30", fallback},
+		{"/archive/2012-13-01", fallback},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		match := new(RouteMatch)
+		if !r.Match(req, match) {
+			t.Errorf("%s: should match", test.path)
+		} else if match.Route != test.route {
+			t.Errorf("%s: matched the wrong route", test.path)
+		}
+	}
+
+	// A subrouter match is discarded if the parent route is not valid.
+	var handled string
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			handled = name
+			w.WriteHeader(200)
+		}
+	}
+	r = NewRouter()
+	r.PathPrefix("/{x}").VarValidator("x", func(s string) bool {
+		return s != "bad"
+	}).Subrouter().HandleFunc("/a", handler("sub"))
+	r.HandleFunc("/bad/a", handler("bad"))
+	for path, name := range map[string]string{"/good/a": "sub", "/bad/a": "bad"} {
+		handled = ""
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		r.ServeHTTP(NewRecorder(), req)
+		if handled != name {
+			t.Errorf("%s: expected handler %q, got %q", path, name, handled)
+		}
+	}
+}
+
+func TestSPAHandler(t *testing.T) {
+	root, err := ioutil.TempDir("", "mux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	static := filepath.Join(root, "static")
+	files := map[string]string{
+		"static/index.html": "index",
+		"static/js/app.js":  "app",
+		"static/docs/.keep": "",
+		"secret.txt":        "secret",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewRouter()
+	r.HandleFunc("/api/users", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("users"))
+	})
+	r.PathPrefix("/api/").Handler(http.NotFoundHandler())
+	r.PathPrefix("/").Handler(SPAHandler(static, "index.html"))
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/js/app.js", 200, "app"},
+		{"/", 200, "index"},
+		{"/users/1/edit", 200, "index"},
+		{"/docs", 200, "index"},
+		{"/api/users", 200, "users"},
+		{"/api/posts", 404, "404 page not found\n"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != test.code {
+			t.Errorf("%s: expected code %d, got %d", test.path, test.code, res.Code)
+		}
+		if body := res.Body.String(); body != test.body {
+			t.Errorf("%s: expected body %q, got %q", test.path, test.body, body)
+		}
+	}
+
+	// Traversal attempts never reach files outside the directory.
+	h := SPAHandler(static, "index.html")
+	for _, p := range []string{"/../secret.txt", "/js/../../secret.txt", "/..\\secret.txt"} {
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		req.URL.Path = p
+		res := NewRecorder()
+		h.ServeHTTP(res, req)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected code %d, got %d", p, http.StatusBadRequest, res.Code)
+		}
+		if strings.Contains(res.Body.String(), "secret") {
+			t.Errorf("%s: served a file outside the static directory", p)
+		}
+	}
+}
+
+func TestRouteMetadata(t *testing.T) {
+	type scopeKey struct{}
+	var scope interface{}
+	var found bool
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		scope, found = RouteMetadata(req, scopeKey{})
+	}
+	r := NewRouter()
+	r.HandleFunc("/admin", handler).Metadata(scopeKey{}, "admin").Metadata("other", 1)
+	r.HandleFunc("/public", handler)
+
+	tests := []struct {
+		path  string
+		scope interface{}
+		found bool
+	}{
+		{"/admin", "admin", true},
+		{"/public", nil, false},
+	}
+	for _, test := range tests {
+		scope, found = nil, false
+		req, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		r.ServeHTTP(NewRecorder(), req)
+		if scope != test.scope || found != test.found {
+			t.Errorf("%s: expected (%v, %v), got (%v, %v)", test.path, test.scope, test.found, scope, found)
+		}
+	}
+
+	// Without a matched route there's no metadata.
+	req, _ := http.NewRequest("GET", "http://localhost/admin", nil)
+	if _, ok := RouteMetadata(req, scopeKey{}); ok {
+		t.Errorf("Expected no metadata without a matched route")
+	}
+}
+
+func TestMatchAll(t *testing.T) {
+	r := NewRouter()
+	users := r.Path("/users/{id}")
+	usersNew := r.Path("/users/new")
+	r.Path("/posts").Methods("POST")
+	api := r.PathPrefix("/api").Subrouter()
+	apiUser := api.Path("/users/{id}")
+	apiAny := api.PathPrefix("/")
+	r.Host("other.com").Subrouter().Path("/users/{id}")
+
+	tests := []struct {
+		method string
+		url    string
+		routes []*Route
+	}{
+		{"GET", "http://localhost/users/new", []*Route{users, usersNew}},
+		{"GET", "http://localhost/users/1", []*Route{users}},
+		{"GET", "http://localhost/posts", nil},
+		{"GET", "http://localhost/api/users/1", []*Route{apiUser, apiAny}},
+		{"GET", "http://localhost/api/posts", []*Route{apiAny}},
+	}
+	for _, test := range tests {
+		matches := r.MatchAll(test.method, test.url)
+		if len(matches) != len(test.routes) {
+			t.Errorf("%s %s: expected %d matches, got %d", test.method, test.url, len(test.routes), len(matches))
+			continue
+		}
+		for k, match := range matches {
+			if match.Route != test.routes[k] {
+				t.Errorf("%s %s: match %d is the wrong route", test.method, test.url, k)
+			}
+		}
+	}
+
+	// Variables are set for each match.
+	matches := r.MatchAll("GET", "http://localhost/users/new")
+	if len(matches) != 2 || matches[0].Vars["id"] != "new" || matches[1].Vars != nil {
+		t.Errorf("Unexpected variables for /users/new")
+	}
+}
+
+func TestCollapseSlashes(t *testing.T) {
+	var path string
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		path = req.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}
+	tests := []struct {
+		collapse bool
+		method   string
+		path     string
+		code     int
+		location string
+		matched  string
+	}{
+		{false, "GET", "/a//b", 301, "/a/b", ""},
+		{false, "POST", "/a//b", 301, "/a/b", ""},
+		{true, "GET", "/a//b", 200, "", "/a/b"},
+		{true, "POST", "//a///b", 200, "", "/a/b"},
+		{true, "GET", "/a//b/", 404, "", ""},
+		{true, "GET", "/a/./b", 301, "/a/b", ""},
+		{true, "GET", "/a/b", 200, "", "/a/b"},
+	}
+	for _, test := range tests {
+		path = ""
+		r := NewRouter().CollapseSlashes(test.collapse)
+		r.HandleFunc("/a/b", handler)
+		req, _ := http.NewRequest(test.method, "http://localhost/", nil)
+		req.URL.Path = test.path
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != test.code {
+			t.Errorf("%s %s: expected code %d, got %d", test.method, test.path, test.code, res.Code)
+		}
+		if location := res.HeaderMap.Get("Location"); location != test.location {
+			t.Errorf("%s %s: expected location %q, got %q", test.method, test.path, test.location, location)
+		}
+		if path != test.matched {
+			t.Errorf("%s %s: expected handler path %q, got %q", test.method, test.path, test.matched, path)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	r := NewRouter()
+	r.HandleFunc("/users", nil).Methods("GET")
+	r.HandleFunc("/users", nil).Methods("POST", "GET")
+	r.Host("other.com").Path("/users").Methods("DELETE")
+	r.Schemes("https").Path("/users").Methods("PUT")
+	r.Path("/items/{id}").Methods("GET").VarValidator("id", func(s string) bool {
+		_, err := strconv.Atoi(s)
+		return err == nil
+	})
+	r.Methods("GET").Host("{lang}.example.com").Path("/{lang}/home")
+
+	tests := []struct {
+		method string
+		url    string
+		code   int
+		allow  string
+	}{
+		{"PATCH", "http://localhost/items/1", 405, "GET"},
+		{"PATCH", "http://localhost/items/abc", 404, ""},
+		{"PATCH", "http://en.example.com/en/home", 405, "GET"},
+		{"PATCH", "http://en.example.com/pt/home", 404, ""},
+		{"PATCH", "http://localhost/users", 405, "GET, POST"},
+		{"PATCH", "http://other.com/users", 405, "GET, POST, DELETE"},
+		{"PATCH", "https://localhost/users", 405, "GET, POST, PUT"},
+		{"PATCH", "http://localhost/posts", 404, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.url, nil)
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != test.code {
+			t.Errorf("%s %s: expected code %d, got %d", test.method, test.url, test.code, res.Code)
+		}
+		if allow := res.HeaderMap.Get("Allow"); allow != test.allow {
+			t.Errorf("%s %s: expected Allow %q, got %q", test.method, test.url, test.allow, allow)
+		}
+	}
+
+	// The match error tells a method mismatch from no match.
+	req, _ := http.NewRequest("PATCH", "http://localhost/users", nil)
+	match := new(RouteMatch)
+	if r.Match(req, match) || match.MatchErr != ErrMethodMismatch {
+		t.Errorf("Expected ErrMethodMismatch, got %v", match.MatchErr)
+	}
+	req, _ = http.NewRequest("PATCH", "http://localhost/posts", nil)
+	match = new(RouteMatch)
+	if r.Match(req, match) || match.MatchErr != nil {
+		t.Errorf("Expected no match error, got %v", match.MatchErr)
+	}
+}
+
+func TestGetTemplates(t *testing.T) {
+	r := NewRouter().StrictSlash(true)
+	articles := r.Host("{subdomain}.domain.com").Path("/articles/{id:[0-9]+}/")
+	sub := r.PathPrefix("/api/").Subrouter()
+	users := sub.Path("/users/{id}")
+	methods := r.Methods("GET")
+
+	tests := []struct {
+		route *Route
+		path  string
+		host  string
+	}{
+		{articles, "/articles/{id:[0-9]+}/", "{subdomain}.domain.com"},
+		{users, "/api/users/{id}", ""},
+		{methods, "", ""},
+	}
+	for _, test := range tests {
+		path, err := test.route.GetPathTemplate()
+		if path != test.path || (err != nil) != (test.path == "") {
+			t.Errorf("Expected path template %q, got %q (%v)", test.path, path, err)
+		}
+		host, err := test.route.GetHostTemplate()
+		if host != test.host || (err != nil) != (test.host == "") {
+			t.Errorf("Expected host template %q, got %q (%v)", test.host, host, err)
+		}
+	}
+
+	// Building errors are returned.
+	if _, err := r.Path("/{id").GetPathTemplate(); err == nil {
+		t.Errorf("Expected error for a bad template")
+	}
+}
+
+func TestResource(t *testing.T) {
+	var calls []string
+	middleware := func(name string) func(http.Handler) http.Handler {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				calls = append(calls, name)
+				h.ServeHTTP(w, req)
+			})
+		}
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls = append(calls, "handler:"+req.Method+":"+Vars(req)["id"])
+	})
+	r := NewRouter()
+	res := r.Resource("/articles/{id}")
+	res.Get(handler).Name("article")
+	res.Post(handler, middleware("csrf"), middleware("auth"))
+	res.Delete(handler, middleware("auth"))
+
+	tests := []struct {
+		method string
+		calls  []string
+	}{
+		{"GET", []string{"handler:GET:1"}},
+		{"POST", []string{"csrf", "auth", "handler:POST:1"}},
+		{"DELETE", []string{"auth", "handler:DELETE:1"}},
+		{"PUT", nil},
+	}
+	for _, test := range tests {
+		calls = nil
+		req, _ := http.NewRequest(test.method, "http://localhost/articles/1", nil)
+		r.ServeHTTP(NewRecorder(), req)
+		if fmt.Sprint(calls) != fmt.Sprint(test.calls) {
+			t.Errorf("%s: expected calls %v, got %v", test.method, test.calls, calls)
+		}
+	}
+
+	if r.Get("article") == nil {
+		t.Errorf("Expected the GET route to be named")
+	}
+}
+
+func TestUse(t *testing.T) {
+	var calls []string
+	middleware := func(name string) func(http.Handler) http.Handler {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				calls = append(calls, name)
+				h.ServeHTTP(w, req)
+			})
+		}
+	}
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		calls = append(calls, "handler")
+	}
+	r := NewRouter()
+	r.Use(middleware("log"), middleware("recover"))
+	r.HandleFunc("/", handler)
+	api := r.PathPrefix("/api").Subrouter().Use(middleware("auth"))
+	api.HandleFunc("/users", handler).Methods("GET")
+	r.Use(middleware("metrics"))
+
+	tests := []struct {
+		method string
+		path   string
+		calls  []string
+	}{
+		{"GET", "/", []string{"log", "recover", "metrics", "handler"}},
+		{"GET", "/api/users", []string{"log", "recover", "metrics", "auth", "handler"}},
+		{"POST", "/api/users", nil},
+		{"GET", "/other", nil},
+	}
+	for _, test := range tests {
+		calls = nil
+		req, _ := http.NewRequest(test.method, "http://localhost"+test.path, nil)
+		r.ServeHTTP(NewRecorder(), req)
+		if fmt.Sprint(calls) != fmt.Sprint(test.calls) {
+			t.Errorf("%s %s: expected calls %v, got %v", test.method, test.path, test.calls, calls)
+		}
+	}
+
+	// Middleware can be attached to the NotFoundHandler.
+	r.NotFoundHandler = middleware("log")(http.NotFoundHandler())
+	calls = nil
+	req, _ := http.NewRequest("GET", "http://localhost/other", nil)
+	r.ServeHTTP(NewRecorder(), req)
+	if fmt.Sprint(calls) != "[log]" {
+		t.Errorf("Expected calls [log], got %v", calls)
+	}
+}
+
+func TestQueriesRegexp(t *testing.T) {
+	r := NewRouter()
+	list := r.Path("/articles/{category}").Queries("page", "{page:[0-9]+}", "sort", "", "lang", "en")
+	search := r.Path("/search").Queries("q", "{query}", "p", "page-{page:[0-9]+}")
+
+	tests := []struct {
+		url   string
+		route *Route
+		vars  map[string]string
+	}{
+		{"http://localhost/articles/go?page=2&sort=date&lang=en", list, map[string]string{"category": "go", "page": "2"}},
+		{"http://localhost/articles/go?page=x&page=3&sort=&lang=en", list, map[string]string{"category": "go", "page": "3"}},
+		{"http://localhost/articles/go?page=x&sort=date&lang=en", nil, nil},
+		{"http://localhost/articles/go?page=2&lang=en", nil, nil},
+		{"http://localhost/articles/go?page=2&sort=date&lang=pt", nil, nil},
+		{"http://localhost/search?q=a+b%2Fc&p=page-10", search, map[string]string{"query": "a b/c", "page": "10"}},
+		{"http://localhost/search?q=&p=page-1", search, map[string]string{"query": "", "page": "1"}},
+		{"http://localhost/search?q=go&p=10", nil, nil},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		match := new(RouteMatch)
+		matched := r.Match(req, match)
+		if matched != (test.route != nil) {
+			t.Errorf("%s: expected match %v, got %v", test.url, test.route != nil, matched)
+			continue
+		}
+		if matched && (match.Route != test.route || !stringMapEqual(match.Vars, test.vars)) {
+			t.Errorf("%s: expected vars %v, got %v", test.url, test.vars, match.Vars)
+		}
+	}
+
+	// Query values are added to built URLs.
+	u, err := search.URL("query", "a b", "page", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := u.String(); s != "/search?p=page-2&q=a+b" {
+		t.Errorf("Expected URL %q, got %q", "/search?p=page-2&q=a+b", s)
+	}
+	if _, err := list.URL("category", "go", "page", "x"); err == nil {
+		t.Errorf("Expected error for an invalid query variable")
+	}
+
+	// Literal values are also added, so the URL matches the route.
+	u, err = list.URL("category", "go", "page", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := u.String(); s != "/articles/go?lang=en&page=2&sort=" {
+		t.Errorf("Expected URL %q, got %q", "/articles/go?lang=en&page=2&sort=", s)
+	}
+	sub := r.PathPrefix("/admin").Queries("debug", "1").Subrouter()
+	users := sub.Path("/users").Queries("page", "{page:[0-9]+}")
+	for _, route := range []*Route{list, users} {
+		u, err = route.URL("category", "go", "page", "2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("GET", "http://localhost"+u.String(), nil)
+		if match := new(RouteMatch); !r.Match(req, match) || match.Route != route {
+			t.Errorf("Expected the built URL %q to match its route", u)
+		}
+	}
+
+	// Variables must be unique in the route.
+	if err := r.Path("/{page}").Queries("page", "{page}").GetError(); err == nil {
+		t.Errorf("Expected error for a duplicated variable")
+	}
+}
+
+func TestSkipClean(t *testing.T) {
+	var vars map[string]string
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		vars = Vars(req)
+		w.WriteHeader(http.StatusOK)
+	}
+	tests := []struct {
+		skip     bool
+		uri      string
+		code     int
+		location string
+		vars     map[string]string
+	}{
+		{false, "/files/a%2Fb", 404, "", nil},
+		{false, "/files/a/../b", 301, "/files/b", nil},
+		{false, "//files/b", 301, "/files/b", nil},
+		{true, "/files/a%2Fb", 200, "", map[string]string{"name": "a%2Fb"}},
+		{true, "/files/a%20b", 200, "", map[string]string{"name": "a%20b"}},
+		{true, "/files/..", 200, "", map[string]string{"name": ".."}},
+		{true, "//files/b", 404, "", nil},
+	}
+	for _, test := range tests {
+		vars = nil
+		r := NewRouter().SkipClean(test.skip)
+		r.HandleFunc("/files/{name}", handler)
+		req, _ := http.NewRequest("GET", "http://localhost"+test.uri, nil)
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.Code != test.code {
+			t.Errorf("%s: expected code %d, got %d", test.uri, test.code, res.Code)
+		}
+		if location := res.HeaderMap.Get("Location"); location != test.location {
+			t.Errorf("%s: expected location %q, got %q", test.uri, test.location, location)
+		}
+		if !stringMapEqual(vars, test.vars) {
+			t.Errorf("%s: expected vars %v, got %v", test.uri, test.vars, vars)
+		}
+	}
+
+	// Slash redirects keep encoded characters.
+	redirects := []struct {
+		policy   SlashPolicy
+		uri      string
+		location string
+	}{
+		{SlashAdd, "/files/a%2Fb", "http://localhost/files/a%2Fb/"},
+		{SlashRemove, "/files/a%2Fb/", "http://localhost/files/a%2Fb"},
+		{SlashAdd, "/files/a%20b", "http://localhost/files/a%20b/"},
+	}
+	for _, test := range redirects {
+		r := NewRouter().SkipClean(true).SlashPolicy(test.policy)
+		r.HandleFunc("/files/{name}", handler)
+		req, _ := http.NewRequest("GET", "http://localhost"+test.uri, nil)
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if location := res.HeaderMap.Get("Location"); res.Code != 301 || location != test.location {
+			t.Errorf("%s: expected redirect to %q, got %d %q", test.uri, test.location, res.Code, location)
+		}
+		if req.URL.RequestURI() != test.uri {
+			t.Errorf("%s: request URL was modified to %q", test.uri, req.URL.RequestURI())
+		}
+	}
+}
+
+func TestSharedVars(t *testing.T) {
+	r := NewRouter()
+	articles := r.Host("{lang:[a-z]{2}}.domain.com").Path("/{lang}/articles/{id}").
+		VarDefault("lang", "en")
+	sub := r.Host("{lang}.other.com").Subrouter()
+	home := sub.Path("/{lang}/")
+
+	tests := []struct {
+		url   string
+		route *Route
+		vars  map[string]string
+	}{
+		{"http://pt.domain.com/pt/articles/1", articles, map[string]string{"lang": "pt", "id": "1"}},
+		{"http://pt.domain.com/en/articles/1", nil, nil},
+		{"http://de.other.com/de/", home, map[string]string{"lang": "de"}},
+		{"http://de.other.com/fr/", nil, nil},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		match := new(RouteMatch)
+		matched := r.Match(req, match)
+		if matched != (test.route != nil) {
+			t.Errorf("%s: expected match %v, got %v", test.url, test.route != nil, matched)
+			continue
+		}
+		if matched && (match.Route != test.route || !stringMapEqual(match.Vars, test.vars)) {
+			t.Errorf("%s: expected vars %v, got %v", test.url, test.vars, match.Vars)
+		}
+	}
+
+	// A single value fills host and path, with a default if omitted.
+	urls := []struct {
+		route *Route
+		pairs []string
+		url   string
+	}{
+		{articles, []string{"lang", "pt", "id", "1"}, "http://pt.domain.com/pt/articles/1"},
+		{articles, []string{"id", "1"}, "http://en.domain.com/en/articles/1"},
+		{home, []string{"lang", "de"}, "http://de.other.com/de/"},
+	}
+	for _, test := range urls {
+		u, err := test.route.URL(test.pairs...)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.pairs, err)
+		} else if u.String() != test.url {
+			t.Errorf("%v: expected URL %q, got %q", test.pairs, test.url, u.String())
+		}
+	}
+	if u, err := articles.URLPath("id", "2"); err != nil || u.String() != "/en/articles/2" {
+		t.Errorf("Expected path %q, got %v (%v)", "/en/articles/2", u, err)
+	}
+	if _, err := home.URL(); err == nil {
+		t.Errorf("Expected error for a missing variable without default")
+	}
+}
+
+func TestBuildOnly(t *testing.T) {
+	r := NewRouter()
+	external := r.Host("{lang}.domain.com").Path("/articles/{id}").BuildOnly().Name("external")
+	real := r.Path("/articles/{id}").Name("article")
+
+	req, _ := http.NewRequest("GET", "http://en.domain.com/articles/1", nil)
+	match := new(RouteMatch)
+	if !r.Match(req, match) {
+		t.Fatalf("Expected a match")
+	}
+	if match.Route != real {
+		t.Errorf("Expected the real route to match")
+	}
+	if external.Match(req, new(RouteMatch)) {
+		t.Errorf("Expected the build-only route to never match")
+	}
+
+	if r.Get("external") != external {
+		t.Errorf("Expected the build-only route to be named")
+	}
+	u, err := r.Get("external").URL("lang", "en", "id", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := u.String(); s != "http://en.domain.com/articles/1" {
+		t.Errorf("Expected URL %q, got %q", "http://en.domain.com/articles/1", s)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	r := NewRouter()
+	home := r.HandleFunc("/", nil)
+	sub := r.PathPrefix("/api").Subrouter()
+	users := sub.HandleFunc("/users", nil)
+	prefix := r.PathPrefix("/static")
+	r.Freeze()
+
+	registrations := map[string]func(){
+		"NewRoute":   func() { r.NewRoute() },
+		"HandleFunc": func() { r.HandleFunc("/late", nil) },
+		"Path":       func() { r.Path("/late") },
+		"Subrouter":  func() { sub.Path("/late") },
+		"Route":      func() { prefix.Subrouter() },
+	}
+	for name, fn := range registrations {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic after Freeze", name)
+				}
+			}()
+			fn()
+		}()
+	}
+
+	// Matching still works.
+	for path, route := range map[string]*Route{"/": home, "/api/users": users} {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		match := new(RouteMatch)
+		if !r.Match(req, match) || match.Route != route {
+			t.Errorf("%s: expected a match", path)
+		}
+	}
+}
+
+func TestMethodShortcuts(t *testing.T) {
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(name))
+		})
+	}
+	r := NewRouter()
+	r.Path("/items/{id}").Methods("GET").Handler(handler("get"))
+	r.Post("/items/{id}", handler("post")).Name("post")
+	r.Put("/items/{id}", handler("put"))
+	r.Delete("/items/{id}", handler("delete"))
+	r.Patch("/items/{id}", handler("patch"))
+	r.Head("/items/{id}", handler("head"))
+	r.Options("/items/{id}", handler("options"))
+
+	for _, method := range []string{"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS"} {
+		req, _ := http.NewRequest(method, "http://localhost/items/1", nil)
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if body := res.Body.String(); body != strings.ToLower(method) {
+			t.Errorf("%s: expected body %q, got %q", method, strings.ToLower(method), body)
+		}
+	}
+	if r.Get("post") == nil {
+		t.Errorf("Expected the POST route to be named")
+	}
+}
+
+func TestPort(t *testing.T) {
+	r := NewRouter()
+	admin := r.Port("8443").PathPrefix("/admin")
+	dev := r.Host("{name}.domain.com").Port("{port:80[0-9]{2}}").Name("dev")
+	https := r.Port("443")
+	http80 := r.Port("{port}")
+
+	tests := []struct {
+		url   string
+		route *Route
+		vars  map[string]string
+	}{
+		{"https://localhost:8443/admin/users", admin, nil},
+		{"http://api.domain.com:8080/", dev, map[string]string{"name": "api", "port": "8080"}},
+		{"http://api.domain.com:8180/", http80, map[string]string{"port": "8180"}},
+		{"https://localhost/", https, nil},
+		{"http://localhost/", http80, map[string]string{"port": "80"}},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		match := new(RouteMatch)
+		if !r.Match(req, match) {
+			t.Errorf("%s: expected a match", test.url)
+		} else if match.Route != test.route || !stringMapEqual(match.Vars, test.vars) {
+			t.Errorf("%s: expected vars %v, got %v", test.url, test.vars, match.Vars)
+		}
+	}
+
+	// Non-absolute requests use the Host header.
+	req, _ := http.NewRequest("GET", "/admin", nil)
+	req.Host = "localhost:8443"
+	if match := new(RouteMatch); !r.Match(req, match) || match.Route != admin {
+		t.Errorf("Expected a match for the Host header port")
+	}
+
+	// Ports are added to built URLs.
+	u, err := r.Get("dev").URL("name", "api", "port", "8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := u.String(); s != "http://api.domain.com:8081" {
+		t.Errorf("Expected URL %q, got %q", "http://api.domain.com:8081", s)
+	}
+	if _, err := r.Get("dev").URLHost("name", "api", "port", "9000"); err == nil {
+		t.Errorf("Expected error for an invalid port")
+	}
+}
+
+func TestVarConverters(t *testing.T) {
+	r := NewRouter()
+	r.RegisterVarConverter("hex", "[0-9a-f]+", func(s string) bool {
+		return len(s)%2 == 0
+	})
+	item := r.Path("/items/{id:int}")
+	post := r.Path("/posts/{slug:slug}")
+	user := r.Path("/users/{uuid:uuid}")
+	color := r.Path("/colors/{color:hex}")
+
+	tests := []struct {
+		path  string
+		route *Route
+		vars  map[string]string
+	}{
+		{"/items/42", item, map[string]string{"id": "42"}},
+		{"/items/-7", item, map[string]string{"id": "-7"}},
+		{"/items/abc", nil, nil},
+		{"/items/99999999999999999999999", nil, nil},
+		{"/posts/hello-world", post, map[string]string{"slug": "hello-world"}},
+		{"/posts/Hello-World", nil, nil},
+		{"/posts/hello--world", nil, nil},
+		{"/users/6ba7b810-9dad-11d1-80b4-00c04fd430c8", user,
+			map[string]string{"uuid": "6ba7b810-9dad-11d1-80b4-00c04fd430c8"}},
+		{"/users/6ba7b810", nil, nil},
+		{"/colors/ff00aa", color, map[string]string{"color": "ff00aa"}},
+		{"/colors/fff", nil, nil},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		match := new(RouteMatch)
+		matched := r.Match(req, match)
+		if test.route == nil {
+			if matched {
+				t.Errorf("%s: expected no match", test.path)
+			}
+		} else if !matched || match.Route != test.route {
+			t.Errorf("%s: expected a match", test.path)
+		} else if !stringMapEqual(match.Vars, test.vars) {
+			t.Errorf("%s: expected vars %v, got %v", test.path, test.vars, match.Vars)
+		}
+	}
+
+	// Converter patterns are used to build URLs.
+	if _, err := item.URL("id", "abc"); err == nil {
+		t.Errorf("Expected an error building a URL with an invalid int")
+	}
+	if u, err := item.URL("id", "42"); err != nil || u.Path != "/items/42" {
+		t.Errorf("Expected /items/42, got %v (%v)", u, err)
+	}
+	// The template is kept as written.
+	if tpl, _ := item.GetPathTemplate(); tpl != "/items/{id:int}" {
+		t.Errorf("Expected the original template, got %q", tpl)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat(`{"name": "value"}`, 100)
+	r := NewRouter()
+	r.HandleFunc("/json", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}).Compress()
+	r.HandleFunc("/text", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(body))
+	}).Compress()
+	r.HandleFunc("/image", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(body))
+	}).Compress()
+	r.HandleFunc("/encoded", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "br")
+		w.Write([]byte(body))
+	}).Compress()
+	r.HandleFunc("/nested", compressHandler(compressHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(body))
+		}))).ServeHTTP)
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		compressed     bool
+	}{
+		{"/json", "gzip, deflate", true},
+		{"/json", "*", true},
+		{"/json", "deflate", false},
+		{"/json", "gzip;q=0, *", false},
+		{"/json", "", false},
+		{"/text", "gzip", true},
+		{"/image", "gzip", false},
+		{"/encoded", "gzip", false},
+		{"/nested", "gzip", true},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if test.path != "/nested" && res.HeaderMap.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: expected Vary header, got %q", test.path, res.HeaderMap.Get("Vary"))
+		}
+		got := res.Body.Bytes()
+		if test.compressed {
+			if res.HeaderMap.Get("Content-Encoding") != "gzip" {
+				t.Errorf("%s (%s): expected gzip encoding", test.path, test.acceptEncoding)
+				continue
+			}
+			gz, err := gzip.NewReader(bytes.NewReader(got))
+			if err != nil {
+				t.Fatalf("%s: %v", test.path, err)
+			}
+			if got, err = ioutil.ReadAll(gz); err != nil {
+				t.Fatalf("%s: %v", test.path, err)
+			}
+		} else if res.HeaderMap.Get("Content-Encoding") == "gzip" {
+			t.Errorf("%s (%s): unexpected gzip encoding", test.path, test.acceptEncoding)
+		}
+		if string(got) != body {
+			t.Errorf("%s (%s): unexpected body %q", test.path, test.acceptEncoding, got)
+		}
+	}
+}
+
+func TestServerTiming(t *testing.T) {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(200)
+	}
+	r := NewRouter()
+	r.HandleFunc("/articles/{id}", handler).Name("article")
+	r.HandleFunc("/users/{id}", handler)
+
+	tests := []struct {
+		path string
+		desc string
+	}{
+		{"/articles/1", `route;desc="article";dur=`},
+		{"/users/1", `route;desc="/users/{id}";dur=`},
+		{"/missing", `route;dur=`},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		res := NewRecorder()
+		r.ServeHTTP(res, req)
+		if res.HeaderMap.Get("Server-Timing") != "" {
+			t.Errorf("%s: unexpected Server-Timing header", test.path)
+		}
+		r.ServerTiming(true)
+		res = NewRecorder()
+		r.ServeHTTP(res, req)
+		r.ServerTiming(false)
+		h := res.HeaderMap.Get("Server-Timing")
+		if !strings.HasPrefix(h, test.desc) {
+			t.Errorf("%s: expected Server-Timing %s..., got %q", test.path, test.desc, h)
+		} else if _, err := strconv.ParseFloat(h[len(test.desc):], 64); err != nil {
+			t.Errorf("%s: invalid duration in %q", test.path, h)
+		}
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	api := Matchers{}.Host("{sub}.domain.com").Schemes("https").
+		Headers("X-Api-Version", "1")
+	r := NewRouter()
+	r.NewRoute().Path("/users").ApplyMatchers(api).Name("users")
+	r.NewRoute().Path("/orders").ApplyMatchers(api.Methods("POST")).
+		Name("orders")
+
+	// Extending the set doesn't change it.
+	if len(api) != 3 {
+		t.Fatalf("Expected 3 matchers in the set, got %d", len(api))
+	}
+
+	tests := []struct {
+		method  string
+		url     string
+		header  string
+		route   string
+		matched bool
+	}{
+		{"GET", "https://api.domain.com/users", "1", "users", true},
+		{"GET", "http://api.domain.com/users", "1", "users", false},
+		{"GET", "https://api.other.com/users", "1", "users", false},
+		{"GET", "https://api.domain.com/users", "2", "users", false},
+		{"POST", "https://api.domain.com/orders", "1", "orders", true},
+		{"POST", "http://api.domain.com/orders", "1", "orders", false},
+		{"POST", "https://api.other.com/orders", "1", "orders", false},
+		{"POST", "https://api.domain.com/orders", "", "orders", false},
+		{"GET", "https://api.domain.com/orders", "1", "orders", false},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.url, nil)
+		if test.header != "" {
+			req.Header.Set("X-Api-Version", test.header)
+		}
+		var match RouteMatch
+		matched := r.Get(test.route).Match(req, &match)
+		if matched != test.matched {
+			t.Errorf("%s %s (%q): expected matched to be %v", test.method,
+				test.url, test.header, test.matched)
+		}
+		if matched && match.Vars["sub"] != "api" {
+			t.Errorf("%s %s: expected sub var to be %q, got %q", test.method,
+				test.url, "api", match.Vars["sub"])
+		}
+	}
+}
+
+func TestDecodeVars(t *testing.T) {
+	type postParams struct {
+		UserID int    `schema:"id"`
+		Slug   string `schema:"slug"`
+	}
+	var params postParams
+	var err error
+	r := NewRouter()
+	r.HandleFunc("/users/{id}/posts/{slug}", func(w http.ResponseWriter, req *http.Request) {
+		params = postParams{}
+		err = DecodeVars(req, &params)
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost/users/42/posts/hello-world", nil)
+	r.ServeHTTP(NewRecorder(), req)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if params != (postParams{42, "hello-world"}) {
+		t.Errorf("Expected {42 hello-world}, got %+v", params)
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost/users/abc/posts/hello-world", nil)
+	r.ServeHTTP(NewRecorder(), req)
+	if err == nil {
+		t.Errorf("Expected an error for a non-integer id")
+	}
+
+	// Variables without a field are ignored.
+	r.FormatExtensions("json")
+	r.HandleFunc("/users/{id}/{extra}", func(w http.ResponseWriter, req *http.Request) {
+		params = postParams{}
+		err = DecodeVars(req, &params)
+	})
+	for _, path := range []string{"/users/42/posts/hello-world.json", "/users/42/more"} {
+		req, _ = http.NewRequest("GET", "http://localhost"+path, nil)
+		r.ServeHTTP(NewRecorder(), req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", path, err)
+		}
+		if params.UserID != 42 {
+			t.Errorf("%s: expected id 42, got %+v", path, params)
+		}
+	}
+}
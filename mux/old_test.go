@@ -628,7 +628,12 @@ func TestSubRouting(t *testing.T) {
 }
 
 func TestVariableNames(t *testing.T) {
+	// Host and path can share variables.
 	route := new(Route).Host("{arg1}.domain.com").Path("/{arg1}/{arg2:[0-9]+}")
+	if route.err != nil {
+		t.Errorf("Unexpected error for variables shared by host and path: %v", route.err)
+	}
+	route = new(Route).Path("/{arg1}/{arg2:[0-9]+}").Queries("q", "{arg1}")
 	if route.err == nil {
 		t.Errorf("Expected error for duplicated variable names")
 	}
@@ -735,7 +740,7 @@ func TestNewRegexp(t *testing.T) {
 	}
 
 	for pattern, paths := range tests {
-		p, _ = newRouteRegexp(pattern, false, false, false)
+		p, _ = newRouteRegexp(pattern, regexpTypePath, false, false, "", nil)
 		for path, result := range paths {
 			matches = p.regexp.FindStringSubmatch(path)
 			if result == nil {
@@ -7,14 +7,26 @@ package mux
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
+// regexpType defines what a routeRegexp matches.
+type regexpType int
+
+const (
+	regexpTypePath regexpType = iota
+	regexpTypeHost
+	regexpTypeQuery
+	regexpTypePort
+)
+
 // newRouteRegexp parses a route template and returns a routeRegexp,
-// used to match a host or path.
+// used to match a host, path or query value.
 //
 // It will extract named variables, assemble a regexp to be matched, create
 // a "reverse" template to build URLs and compile regexps to validate variable
@@ -23,7 +35,14 @@ import (
 // Previously we accepted only Python-like identifiers for variable
 // names ([a-zA-Z_][a-zA-Z0-9_]*), but currently the only restriction is that
 // name and pattern can't be empty, and names can't contain a colon.
-func newRouteRegexp(tpl string, matchHost, matchPrefix, strictSlash bool) (*routeRegexp, error) {
+//
+// If flags is not empty, it is prepended to all compiled regexps as "(?flags)".
+//
+// A pattern can also be the name of a variable converter, looked up in convs
+// and then in the built-in converters. Its regexp is used instead, and its
+// validator, if any, is stored in the validators of the routeRegexp.
+func newRouteRegexp(tpl string, typ regexpType, matchPrefix, strictSlash bool,
+	flags string, convs map[string]varConverter) (*routeRegexp, error) {
 	// Check if it is well-formed.
 	idxs, errBraces := braceIndices(tpl)
 	if errBraces != nil {
@@ -33,9 +52,16 @@ func newRouteRegexp(tpl string, matchHost, matchPrefix, strictSlash bool) (*rout
 	template := tpl
 	// Now let's parse it.
 	defaultPattern := "[^/]+"
-	if matchHost {
+	switch typ {
+	case regexpTypeHost:
 		defaultPattern = "[^.]+"
 		matchPrefix, strictSlash = false, false
+	case regexpTypeQuery:
+		defaultPattern = ".*"
+		matchPrefix, strictSlash = false, false
+	case regexpTypePort:
+		defaultPattern = "[0-9]+"
+		matchPrefix, strictSlash = false, false
 	}
 	if matchPrefix {
 		strictSlash = false
@@ -48,8 +74,12 @@ func newRouteRegexp(tpl string, matchHost, matchPrefix, strictSlash bool) (*rout
 	}
 	varsN := make([]string, len(idxs)/2)
 	varsR := make([]*regexp.Regexp, len(idxs)/2)
-	pattern := bytes.NewBufferString("^")
+	if flags != "" {
+		flags = "(?" + flags + ")"
+	}
+	pattern := bytes.NewBufferString(flags + "^")
 	reverse := bytes.NewBufferString("")
+	var validators map[string]func(string) bool
 	var end int
 	var err error
 	for i := 0; i < len(idxs); i += 2 {
@@ -67,13 +97,22 @@ func newRouteRegexp(tpl string, matchHost, matchPrefix, strictSlash bool) (*rout
 			return nil, fmt.Errorf("mux: missing name or pattern in %q",
 				tpl[idxs[i]:end])
 		}
+		if conv, ok := lookupVarConverter(convs, patt); ok {
+			patt = conv.pattern
+			if conv.validate != nil {
+				if validators == nil {
+					validators = make(map[string]func(string) bool)
+				}
+				validators[name] = conv.validate
+			}
+		}
 		// Build the regexp pattern.
 		fmt.Fprintf(pattern, "%s(%s)", regexp.QuoteMeta(raw), patt)
 		// Build the reverse template.
 		fmt.Fprintf(reverse, "%s%%s", raw)
 		// Append variable name and compiled pattern.
 		varsN[i/2] = name
-		varsR[i/2], err = regexp.Compile(fmt.Sprintf("^%s$", patt))
+		varsR[i/2], err = regexp.Compile(fmt.Sprintf("%s^%s$", flags, patt))
 		if err != nil {
 			return nil, err
 		}
@@ -98,22 +137,56 @@ func newRouteRegexp(tpl string, matchHost, matchPrefix, strictSlash bool) (*rout
 	}
 	// Done!
 	return &routeRegexp{
-		template:  template,
-		matchHost: matchHost,
-		regexp:    reg,
-		reverse:   reverse.String(),
-		varsN:     varsN,
-		varsR:     varsR,
+		template:   template,
+		typ:        typ,
+		regexp:     reg,
+		reverse:    reverse.String(),
+		varsN:      varsN,
+		varsR:      varsR,
+		validators: validators,
 	}, nil
 }
 
-// routeRegexp stores a regexp to match a host or path and information to
-// collect and validate route variables.
+// varConverter is a named pattern for route variables. See
+// Router.RegisterVarConverter().
+type varConverter struct {
+	pattern  string
+	validate func(string) bool
+}
+
+// defaultVarConverters are the built-in variable converters.
+var defaultVarConverters = map[string]varConverter{
+	"int":  {pattern: "-?[0-9]+", validate: isInt},
+	"uuid": {pattern: "[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}"},
+	"slug": {pattern: "[a-z0-9]+(?:-[a-z0-9]+)*"},
+}
+
+// lookupVarConverter returns the converter with the given name from convs
+// or the built-in converters.
+func lookupVarConverter(convs map[string]varConverter,
+	name string) (varConverter, bool) {
+	if conv, ok := convs[name]; ok {
+		return conv, true
+	}
+	conv, ok := defaultVarConverters[name]
+	return conv, ok
+}
+
+// isInt returns true if s is an integer that fits in an int.
+func isInt(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// routeRegexp stores a regexp to match a host, path or query value and
+// information to collect and validate route variables.
 type routeRegexp struct {
 	// The unmodified template.
 	template string
-	// True for host match, false for path match.
-	matchHost bool
+	// Defines if the regexp matches the host, path or a query value.
+	typ regexpType
+	// The query key, for query values.
+	queryKey string
 	// Expanded regexp.
 	regexp *regexp.Regexp
 	// Reverse template.
@@ -122,14 +195,33 @@ type routeRegexp struct {
 	varsN []string
 	// Variable regexps (validators).
 	varsR []*regexp.Regexp
+	// Validators from variable converters, by variable name.
+	validators map[string]func(string) bool
 }
 
-// Match matches the regexp against the URL host or path.
+// Match matches the regexp against the URL host, path or query value.
 func (r *routeRegexp) Match(req *http.Request, match *RouteMatch) bool {
-	if !r.matchHost {
-		return r.regexp.MatchString(req.URL.Path)
+	switch r.typ {
+	case regexpTypeHost:
+		return r.regexp.MatchString(getHost(req))
+	case regexpTypeQuery:
+		_, ok := r.queryValue(req)
+		return ok
+	case regexpTypePort:
+		return r.regexp.MatchString(getPort(req))
 	}
-	return r.regexp.MatchString(getHost(req))
+	return r.regexp.MatchString(req.URL.Path)
+}
+
+// queryValue returns the first value for the query key that matches the
+// regexp, and true if one was found.
+func (r *routeRegexp) queryValue(req *http.Request) (string, bool) {
+	for _, value := range req.URL.Query()[r.queryKey] {
+		if r.regexp.MatchString(value) {
+			return value, true
+		}
+	}
+	return "", false
 }
 
 // url builds a URL part using the given values.
@@ -162,6 +254,25 @@ func (r *routeRegexp) url(pairs ...string) (string, error) {
 	return rv, nil
 }
 
+// validate returns true if the variables extracted from the given host or
+// path are accepted by the validators, if any.
+func (r *routeRegexp) validate(s string, validators map[string]func(string) bool) bool {
+	var matches []string
+	for k, name := range r.varsN {
+		if fn := validators[name]; fn != nil {
+			if matches == nil {
+				if matches = r.regexp.FindStringSubmatch(s); matches == nil {
+					return false
+				}
+			}
+			if !fn(matches[k+1]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // braceIndices returns the first level curly brace indices from a string.
 // It returns an error in case of unbalanced braces.
 func braceIndices(s string) ([]int, error) {
@@ -193,8 +304,105 @@ func braceIndices(s string) ([]int, error) {
 
 // routeRegexpGroup groups the route matchers that carry variables.
 type routeRegexpGroup struct {
-	host *routeRegexp
-	path *routeRegexp
+	host    *routeRegexp
+	path    *routeRegexp
+	port    *routeRegexp
+	queries []*routeRegexp
+	// True if host and path have variables with the same name.
+	shared bool
+}
+
+// hasVars returns true if the host, path or queries define variables.
+func (v *routeRegexpGroup) hasVars() bool {
+	for _, q := range v.queries {
+		if len(q.varsN) > 0 {
+			return true
+		}
+	}
+	return v.host != nil && len(v.host.varsN) > 0 ||
+		v.path != nil && len(v.path.varsN) > 0 ||
+		v.port != nil && len(v.port.varsN) > 0
+}
+
+// varNames returns the names of all variables in the group.
+func (v *routeRegexpGroup) varNames() []string {
+	var names []string
+	for _, r := range append([]*routeRegexp{v.host, v.path, v.port}, v.queries...) {
+		if r != nil {
+			names = append(names, r.varsN...)
+		}
+	}
+	return names
+}
+
+// validate returns true if the host, path and query variables are accepted
+// by the validators, if any.
+func (v *routeRegexpGroup) validate(req *http.Request,
+	validators map[string]func(string) bool) bool {
+	if v.host != nil && !v.host.validate(getHost(req), validators) {
+		return false
+	}
+	if v.path != nil && !v.path.validate(req.URL.Path, validators) {
+		return false
+	}
+	if v.port != nil && !v.port.validate(getPort(req), validators) {
+		return false
+	}
+	for _, q := range v.queries {
+		if value, _ := q.queryValue(req); !q.validate(value, validators) {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedMatch returns true if the variables defined in both host and path
+// have the same value.
+func (v *routeRegexpGroup) sharedMatch(req *http.Request) bool {
+	hostVars := v.host.regexp.FindStringSubmatch(getHost(req))
+	pathVars := v.path.regexp.FindStringSubmatch(req.URL.Path)
+	if hostVars == nil || pathVars == nil {
+		return false
+	}
+	for i, hn := range v.host.varsN {
+		for j, pn := range v.path.varsN {
+			if hn == pn && hostVars[i+1] != pathVars[j+1] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hostURL builds the URL host using the given values, including the port
+// if the group has a port template.
+func (v *routeRegexpGroup) hostURL(pairs ...string) (string, error) {
+	host, err := v.host.url(pairs...)
+	if err != nil || v.port == nil {
+		return host, err
+	}
+	port, err := v.port.url(pairs...)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// queryURL builds the URL query for the query templates using the given
+// values, adding them to the literal query values. It returns an empty
+// string if there are no query templates or literal values.
+func (v *routeRegexpGroup) queryURL(query url.Values, pairs ...string) (string, error) {
+	if len(v.queries) == 0 && len(query) == 0 {
+		return "", nil
+	}
+	for _, q := range v.queries {
+		value, err := q.url(pairs...)
+		if err != nil {
+			return "", err
+		}
+		query.Add(q.queryKey, value)
+	}
+	return query.Encode(), nil
 }
 
 // setMatch extracts the variables from the URL once a route matches.
@@ -208,6 +416,24 @@ func (v *routeRegexpGroup) setMatch(req *http.Request, m *RouteMatch, r *Route)
 			}
 		}
 	}
+	// Store port variables.
+	if v.port != nil {
+		if portVars := v.port.regexp.FindStringSubmatch(getPort(req)); portVars != nil {
+			for k, v := range v.port.varsN {
+				m.Vars[v] = portVars[k+1]
+			}
+		}
+	}
+	// Store query variables.
+	for _, q := range v.queries {
+		if value, ok := q.queryValue(req); ok {
+			if queryVars := q.regexp.FindStringSubmatch(value); queryVars != nil {
+				for k, v := range q.varsN {
+					m.Vars[v] = queryVars[k+1]
+				}
+			}
+		}
+	}
 	// Store path variables.
 	if v.path != nil {
 		pathVars := v.path.regexp.FindStringSubmatch(req.URL.Path)
@@ -216,18 +442,32 @@ func (v *routeRegexpGroup) setMatch(req *http.Request, m *RouteMatch, r *Route)
 				m.Vars[v] = pathVars[k+1]
 			}
 			// Check if we should redirect.
-			if r.strictSlash {
-				p1 := strings.HasSuffix(req.URL.Path, "/")
-				p2 := strings.HasSuffix(v.path.template, "/")
-				if p1 != p2 {
-					u, _ := url.Parse(req.URL.String())
-					if p1 {
-						u.Path = u.Path[:len(u.Path)-1]
-					} else {
-						u.Path += "/"
+			p1 := strings.HasSuffix(req.URL.Path, "/")
+			p2 := p1
+			switch {
+			case r.slashPolicy == SlashAdd:
+				p2 = true
+			case r.slashPolicy == SlashRemove:
+				p2 = req.URL.Path == "/"
+			case r.strictSlash:
+				p2 = strings.HasSuffix(v.path.template, "/")
+			}
+			// Paths with a format extension are not redirected.
+			if p1 != p2 && m.format == "" {
+				u := *req.URL
+				if m.origURL != nil {
+					u = *m.origURL
+				}
+				if p1 {
+					u.Path = u.Path[:len(u.Path)-1]
+					u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+				} else {
+					u.Path += "/"
+					if u.RawPath != "" {
+						u.RawPath += "/"
 					}
-					m.Handler = http.RedirectHandler(u.String(), 301)
 				}
+				m.Handler = http.RedirectHandler(u.String(), 301)
 			}
 		}
 	}
@@ -235,13 +475,29 @@ func (v *routeRegexpGroup) setMatch(req *http.Request, m *RouteMatch, r *Route)
 
 // getHost tries its best to return the request host.
 func getHost(r *http.Request) string {
-	if !r.URL.IsAbs() {
-		host := r.Host
-		// Slice off any port information.
-		if i := strings.Index(host, ":"); i != -1 {
-			host = host[:i]
-		}
-		return host
+	host := r.Host
+	if r.URL.IsAbs() {
+		host = r.URL.Host
+	}
+	// Slice off any port information.
+	if i := strings.Index(host, ":"); i != -1 {
+		host = host[:i]
+	}
+	return host
+}
+
+// getPort returns the request port, or the default port for the scheme if
+// it is not set.
+func getPort(r *http.Request) string {
+	host := r.Host
+	if r.URL.IsAbs() {
+		host = r.URL.Host
+	}
+	if _, port, err := net.SplitHostPort(host); err == nil {
+		return port
+	}
+	if getScheme(r) == "https" {
+		return "443"
 	}
-	return r.URL.Host
+	return "80"
 }
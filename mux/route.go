@@ -5,11 +5,15 @@
 package mux
 
 import (
+	"compress/gzip"
 	"errors"
 	"fmt"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // Route stores information to match a request and build URLs.
@@ -25,12 +29,36 @@ type Route struct {
 	// If true, when the path pattern is "/path/", accessing "/path" will
 	// redirect to the former and vice versa.
 	strictSlash bool
+	// Defines a canonical form for trailing slashes. See Router.SlashPolicy().
+	slashPolicy SlashPolicy
+	// Flags prepended to host and path regexps. See Router.SetRegexpFlags().
+	regexpFlags string
+	// Variable converters. See Router.RegisterVarConverter().
+	varConverters map[string]varConverter
+	// If true, the client address is read from X-Forwarded-For.
+	trustForwardedFor bool
 	// If true, this route never matches: it is only used to build URLs.
 	buildOnly bool
+	// If set, requests are redirected to the canonical host or scheme.
+	canonical *CanonicalOptions
+	// If set, the handler is wrapped to handle conditional requests.
+	cacheable *cacheable
+	// If true, responses are compressed with gzip. See Route.Compress().
+	compress bool
+	// If set, OPTIONS requests are answered with these methods as allowed.
+	allowMethods []string
+	// Custom validators for route variables.
+	validators map[string]func(string) bool
+	// Default values for route variables, used to build URLs.
+	defaults map[string]string
+	// Arbitrary values set with Route.Metadata().
+	metadata map[interface{}]interface{}
 	// The name used to build URLs.
 	name string
 	// Error resulted from building a route.
 	err error
+	// All errors resulted from building a route.
+	errs []error
 }
 
 // Match matches the route against the request.
@@ -38,38 +66,109 @@ func (r *Route) Match(req *http.Request, match *RouteMatch) bool {
 	if r.buildOnly || r.err != nil {
 		return false
 	}
+	// A subrouter matcher sets the route, handler and variables of the
+	// match, so they are restored if the route doesn't match after that.
+	saved := *match
+	if match.Vars != nil {
+		saved.Vars = make(map[string]string, len(match.Vars))
+		for k, v := range match.Vars {
+			saved.Vars[k] = v
+		}
+	}
 	// Match everything.
-	for _, m := range r.matchers {
+	for k, m := range r.matchers {
 		if matched := m.Match(req, match); !matched {
+			if methods, ok := m.(methodMatcher); ok &&
+				matchIgnoringMethods(req, r.matchers[k+1:]) &&
+				r.validVars(req) {
+				// Only the method didn't match.
+				match.MatchErr = ErrMethodMismatch
+				match.allowedMethods = append(match.allowedMethods,
+					methods...)
+			}
+			match.restore(&saved)
 			return false
 		}
 	}
+	if !r.validVars(req) {
+		match.restore(&saved)
+		return false
+	}
 	// Yay, we have a match. Let's collect some info about it.
+	match.MatchErr = nil
+	match.allowedMethods = nil
 	if match.Route == nil {
 		match.Route = r
 	}
 	if match.Handler == nil {
 		match.Handler = r.handler
-	}
-	if match.Vars == nil {
-		match.Vars = make(map[string]string)
+		if r.cacheable != nil && r.handler != nil {
+			match.Handler = r.cacheable.wrap(r.handler)
+		}
+		if r.compress && r.handler != nil {
+			match.Handler = compressHandler(match.Handler)
+		}
 	}
 	// Set variables.
 	if r.regexp != nil {
+		if match.Vars == nil && r.regexp.hasVars() {
+			match.Vars = make(map[string]string)
+		}
 		r.regexp.setMatch(req, match, r)
 	}
+	// Check if we should answer an OPTIONS request.
+	if r.allowMethods != nil && req.Method == "OPTIONS" {
+		match.Handler = allowHandler(r.allowMethods)
+	}
+	// Check if we should redirect to the canonical host or scheme.
+	if r.canonical != nil {
+		if u := r.canonical.redirectURL(req); u != "" {
+			match.Handler = http.RedirectHandler(u, r.canonical.code())
+		}
+	}
 	return true
 }
 
+// validVars returns true if the route variables pass the validators, and
+// the variables shared by host and path have the same value.
+func (r *Route) validVars(req *http.Request) bool {
+	if r.validators != nil && r.regexp != nil &&
+		!r.regexp.validate(req, r.validators) {
+		return false
+	}
+	return r.regexp == nil || !r.regexp.shared || r.regexp.sharedMatch(req)
+}
+
 // ----------------------------------------------------------------------------
 // Route attributes
 // ----------------------------------------------------------------------------
 
 // GetError returns an error resulted from building the route, if any.
+//
+// If building the route resulted in more than one error, a MultiError is
+// returned. See Route.GetErrors().
 func (r *Route) GetError() error {
 	return r.err
 }
 
+// GetErrors returns all errors resulted from building the route, if any.
+func (r *Route) GetErrors() []error {
+	return r.errs
+}
+
+// addError records an error resulted from building the route.
+func (r *Route) addError(err error) {
+	if err == nil {
+		return
+	}
+	r.errs = append(r.errs, err)
+	if len(r.errs) == 1 {
+		r.err = err
+	} else {
+		r.err = MultiError(r.errs)
+	}
+}
+
 // BuildOnly sets the route to never match: it is only used to build URLs.
 func (r *Route) BuildOnly() *Route {
 	r.buildOnly = true
@@ -80,9 +179,7 @@ func (r *Route) BuildOnly() *Route {
 
 // Handler sets a handler for the route.
 func (r *Route) Handler(handler http.Handler) *Route {
-	if r.err == nil {
-		r.handler = handler
-	}
+	r.handler = handler
 	return r
 }
 
@@ -96,19 +193,320 @@ func (r *Route) GetHandler() http.Handler {
 	return r.handler
 }
 
+// Canonical ------------------------------------------------------------------
+
+// CanonicalOptions defines the canonical host and scheme for a route.
+type CanonicalOptions struct {
+	// Canonical host, e.g. "www.domain.com". Empty means any host.
+	Host string
+	// Canonical scheme, e.g. "https". Empty means any scheme.
+	Scheme string
+	// Redirect status code. Defaults to 301 (http.StatusMovedPermanently).
+	Code int
+}
+
+// code returns the redirect status code.
+func (o *CanonicalOptions) code() int {
+	if o.Code == 0 {
+		return http.StatusMovedPermanently
+	}
+	return o.Code
+}
+
+// redirectURL returns the canonical URL for the request, or an empty string
+// if the request already uses the canonical host and scheme.
+func (o *CanonicalOptions) redirectURL(req *http.Request) string {
+	scheme, host := getScheme(req), getHost(req)
+	if (o.Scheme == "" || o.Scheme == scheme) && (o.Host == "" || o.Host == host) {
+		return ""
+	}
+	if o.Scheme != "" {
+		scheme = o.Scheme
+	}
+	if o.Host != "" {
+		host = o.Host
+	}
+	return canonicalURL(req, scheme, host)
+}
+
+// Canonical sets the canonical host and scheme for the route.
+//
+// When the route matches a request that doesn't use the canonical host or
+// scheme, it redirects to the canonical URL, preserving path and query.
+// For example:
+//
+//     r := mux.NewRouter()
+//     r.PathPrefix("/").Canonical(mux.CanonicalOptions{
+//         Host:   "www.domain.com",
+//         Scheme: "https",
+//     }).Handler(SiteHandler)
+//
+// The above route redirects "http://domain.com/foo?bar=baz" to
+// "https://www.domain.com/foo?bar=baz".
+func (r *Route) Canonical(opts CanonicalOptions) *Route {
+	r.canonical = &opts
+	return r
+}
+
+// SlashPolicy ----------------------------------------------------------------
+
+// SlashPolicy defines a canonical form for trailing slashes in URL paths.
+// See Router.SlashPolicy().
+type SlashPolicy int
+
+const (
+	SlashOff    SlashPolicy = iota // don't redirect.
+	SlashAdd                       // redirect to the path with a trailing slash.
+	SlashRemove                    // redirect to the path without a trailing slash.
+)
+
+// Cacheable ------------------------------------------------------------------
+
+// cacheable handles conditional GET requests for a route.
+type cacheable struct {
+	etag         func(*http.Request) string
+	lastModified func(*http.Request) time.Time
+}
+
+// wrap returns a handler that sets the ETag and Last-Modified headers and
+// responds with 304 (http.StatusNotModified) if the request conditions
+// match, without calling the given handler.
+func (c *cacheable) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			h.ServeHTTP(w, req)
+			return
+		}
+		var etag string
+		var modified time.Time
+		if c.etag != nil {
+			if etag = c.etag(req); etag != "" {
+				if !strings.HasPrefix(etag, "\"") &&
+					!strings.HasPrefix(etag, "W/") {
+					etag = "\"" + etag + "\""
+				}
+				w.Header().Set("ETag", etag)
+			}
+		}
+		if c.lastModified != nil {
+			if modified = c.lastModified(req); !modified.IsZero() {
+				w.Header().Set("Last-Modified",
+					modified.UTC().Format(http.TimeFormat))
+			}
+		}
+		if notModified(req, etag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// notModified returns true if the conditional request headers match the
+// given ETag or modification time.
+//
+// If-None-Match takes precedence over If-Modified-Since, as defined in
+// RFC 7232.
+func notModified(req *http.Request, etag string, modified time.Time) bool {
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		if etag == "" {
+			return false
+		}
+		for _, v := range strings.Split(match, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.TrimPrefix(v, "W/") ==
+				strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if since := req.Header.Get("If-Modified-Since"); since != "" &&
+		!modified.IsZero() {
+		if t, err := http.ParseTime(since); err == nil {
+			return !modified.Truncate(time.Second).After(t)
+		}
+	}
+	return false
+}
+
+// Cacheable sets the route to handle conditional GET requests.
+//
+// For GET and HEAD requests, the given functions are called to compute the
+// ETag and last modification time of the requested resource, which are set
+// as response headers. If the request If-None-Match or If-Modified-Since
+// headers match, the response is 304 (http.StatusNotModified) and the route
+// handler is not called.
+//
+// Any of the functions can be nil, and they can return an empty ETag or a
+// zero time to skip the corresponding header. For example:
+//
+//     r := mux.NewRouter()
+//     r.HandleFunc("/articles/{id}", ArticleHandler).
+//       Cacheable(ArticleETag, nil)
+func (r *Route) Cacheable(etagFunc func(*http.Request) string,
+	lastModFunc func(*http.Request) time.Time) *Route {
+	r.cacheable = &cacheable{etag: etagFunc, lastModified: lastModFunc}
+	return r
+}
+
+// Compress -------------------------------------------------------------------
+
+// Compress sets the route to compress responses with gzip.
+//
+// Responses are compressed when the request Accept-Encoding header allows
+// gzip and the response content type is compressible: text types, JSON,
+// JavaScript, XML and SVG. Other types, like images, are sent unchanged.
+// If the Content-Type header is not set, it is detected from the first
+// bytes written, as done by the net/http package.
+//
+// Responses with a Content-Encoding header set by the handler are never
+// compressed again. For example:
+//
+//     r := mux.NewRouter()
+//     r.HandleFunc("/reports/{id}", ReportHandler).Compress()
+func (r *Route) Compress() *Route {
+	r.compress = true
+	return r
+}
+
+// compressHandler returns a handler that compresses the responses of h.
+func compressHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := w.(*gzipResponseWriter); ok {
+			// Already compressing.
+			h.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(req) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		h.ServeHTTP(gw, req)
+	})
+}
+
+// acceptsGzip returns true if the request Accept-Encoding header allows
+// gzip, explicitly or with "*". A quality value of zero disallows it.
+func acceptsGzip(req *http.Request) bool {
+	// For each coding: -1 if not listed, 0 if refused, 1 if accepted.
+	gzipQ, anyQ := -1, -1
+	for _, v := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(v, ";")
+		q := 1
+		for _, p := range params[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") &&
+				strings.Trim(p[2:], "0.") == "" {
+				q = 0
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ == 1
+	}
+	return anyQ == 1
+}
+
+// compressible returns true if responses with the given content type
+// benefit from compression.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml",
+		"image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response body if the response content
+// type is compressible. The decision is taken when the header is written.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+// WriteHeader sets the Content-Encoding header if the response will be
+// compressed, and writes the header.
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if h.Get("Content-Encoding") == "" && code >= 200 &&
+		code != http.StatusNoContent && code != http.StatusNotModified &&
+		compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes data to the response, compressed if needed.
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends buffered compressed data to the client, if the underlying
+// ResponseWriter supports it.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close flushes the compressed data.
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
 // Name -----------------------------------------------------------------------
 
 // Name sets the name for the route, used to build URLs.
 // If the name was registered already it will be overwritten.
 func (r *Route) Name(name string) *Route {
 	if r.name != "" {
-		r.err = fmt.Errorf("mux: route already has name %q, can't set %q",
-			r.name, name)
-	}
-	if r.err == nil {
-		r.name = name
-		r.getNamedRoutes()[name] = r
+		r.addError(fmt.Errorf("mux: route already has name %q, can't set %q",
+			r.name, name))
+		return r
 	}
+	r.name = name
+	r.getNamedRoutes()[name] = r
 	return r
 }
 
@@ -117,6 +515,37 @@ func (r *Route) GetName() string {
 	return r.name
 }
 
+// Metadata -------------------------------------------------------------------
+
+// Metadata stores an arbitrary value in the route for the given key.
+//
+// Handlers can read it from the matched route, e.g. to check route-declared
+// configuration such as required scopes:
+//
+//     r := mux.NewRouter()
+//     r.HandleFunc("/admin", AdminHandler).Metadata("scope", "admin")
+//
+//     func AdminHandler(w http.ResponseWriter, r *http.Request) {
+//         scope, _ := mux.RouteMetadata(r, "scope")
+//         ...
+//     }
+//
+// Keys must be comparable. See also RouteMetadata().
+func (r *Route) Metadata(key, value interface{}) *Route {
+	if r.metadata == nil {
+		r.metadata = make(map[interface{}]interface{})
+	}
+	r.metadata[key] = value
+	return r
+}
+
+// GetMetadata returns the value stored in the route for the given key, and
+// true if the key was set.
+func (r *Route) GetMetadata(key interface{}) (interface{}, bool) {
+	value, ok := r.metadata[key]
+	return value, ok
+}
+
 // ----------------------------------------------------------------------------
 // Matchers
 // ----------------------------------------------------------------------------
@@ -127,18 +556,17 @@ type matcher interface {
 }
 
 // addMatcher adds a matcher to the route.
+//
+// Matchers are added even if building the route resulted in an error, so
+// that later builders keep configuring the route and recording their own
+// errors. A route with errors never matches.
 func (r *Route) addMatcher(m matcher) *Route {
-	if r.err == nil {
-		r.matchers = append(r.matchers, m)
-	}
+	r.matchers = append(r.matchers, m)
 	return r
 }
 
 // addRegexpMatcher adds a host or path matcher and builder to a route.
 func (r *Route) addRegexpMatcher(tpl string, matchHost, matchPrefix bool) error {
-	if r.err != nil {
-		return r.err
-	}
 	r.regexp = r.getRegexpGroup()
 	if !matchHost {
 		if len(tpl) == 0 || tpl[0] != '/' {
@@ -148,29 +576,96 @@ func (r *Route) addRegexpMatcher(tpl string, matchHost, matchPrefix bool) error
 			tpl = strings.TrimRight(r.regexp.path.template, "/") + tpl
 		}
 	}
-	rr, err := newRouteRegexp(tpl, matchHost, matchPrefix, r.strictSlash)
+	typ := regexpTypePath
+	if matchHost {
+		typ = regexpTypeHost
+	}
+	rr, err := r.newRegexp(tpl, typ, matchPrefix,
+		r.strictSlash || r.slashPolicy != SlashOff)
 	if err != nil {
 		return err
 	}
+	// Host and path variables can't be used in queries or port.
+	var otherVars []string
+	for _, q := range r.regexp.queries {
+		otherVars = append(otherVars, q.varsN...)
+	}
+	if r.regexp.port != nil {
+		otherVars = append(otherVars, r.regexp.port.varsN...)
+	}
+	if err = uniqueVars(rr.varsN, otherVars); err != nil {
+		return err
+	}
+	// Host and path can share variables: they must have the same value.
 	if matchHost {
-		if r.regexp.path != nil {
-			if err = uniqueVars(rr.varsN, r.regexp.path.varsN); err != nil {
-				return err
-			}
-		}
 		r.regexp.host = rr
 	} else {
-		if r.regexp.host != nil {
-			if err = uniqueVars(rr.varsN, r.regexp.host.varsN); err != nil {
-				return err
-			}
-		}
 		r.regexp.path = rr
 	}
+	r.regexp.shared = r.regexp.host != nil && r.regexp.path != nil &&
+		uniqueVars(r.regexp.host.varsN, r.regexp.path.varsN) != nil
 	r.addMatcher(rr)
 	return nil
 }
 
+// newRegexp parses a template using the regexp flags and variable converters
+// of the route. Validators from converters are added to the route.
+func (r *Route) newRegexp(tpl string, typ regexpType, matchPrefix,
+	strictSlash bool) (*routeRegexp, error) {
+	rr, err := newRouteRegexp(tpl, typ, matchPrefix, strictSlash,
+		r.regexpFlags, r.varConverters)
+	if err != nil {
+		return nil, err
+	}
+	for name, fn := range rr.validators {
+		r.VarValidator(name, fn)
+	}
+	return rr, nil
+}
+
+// addQueryMatcher adds a matcher for the values of a query key, using a
+// template with variables.
+func (r *Route) addQueryMatcher(key, tpl string) error {
+	r.regexp = r.getRegexpGroup()
+	rr, err := r.newRegexp(tpl, regexpTypeQuery, false, false)
+	if err != nil {
+		return err
+	}
+	rr.queryKey = key
+	if err = uniqueVars(rr.varsN, r.regexp.varNames()); err != nil {
+		return err
+	}
+	r.regexp.queries = append(r.regexp.queries, rr)
+	r.addMatcher(rr)
+	return nil
+}
+
+// BodyContentType ------------------------------------------------------------
+
+// contentTypeMatcher matches the request against body media types.
+type contentTypeMatcher []string
+
+func (m contentTypeMatcher) Match(r *http.Request, match *RouteMatch) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return matchInArray(m, mediaType)
+}
+
+// BodyContentType adds a matcher for the media type of the request body.
+// It accepts a sequence of one or more media types to be matched, e.g.:
+// "application/json", "application/xml".
+//
+// The Content-Type header is parsed and parameters are ignored, so
+// "application/json; charset=utf-8" matches "application/json".
+func (r *Route) BodyContentType(types ...string) *Route {
+	for k, v := range types {
+		types[k] = strings.ToLower(v)
+	}
+	return r.addMatcher(contentTypeMatcher(types))
+}
+
 // Headers --------------------------------------------------------------------
 
 // headerMatcher matches the request against header values.
@@ -191,12 +686,12 @@ func (m headerMatcher) Match(r *http.Request, match *RouteMatch) bool {
 //
 // It the value is an empty string, it will match any value if the key is set.
 func (r *Route) Headers(pairs ...string) *Route {
-	if r.err == nil {
-		var headers map[string]string
-		headers, r.err = mapFromPairs(pairs...)
-		return r.addMatcher(headerMatcher(headers))
+	headers, err := mapFromPairs(pairs...)
+	if err != nil {
+		r.addError(err)
+		return r
 	}
-	return r
+	return r.addMatcher(headerMatcher(headers))
 }
 
 // Host -----------------------------------------------------------------------
@@ -216,10 +711,10 @@ func (r *Route) Headers(pairs ...string) *Route {
 //     r.Host("{subdomain}.domain.com")
 //     r.Host("{subdomain:[a-z]+}.domain.com")
 //
-// Variable names must be unique in a given route. They can be retrieved
-// calling mux.Vars(request).
+// Variable names must be unique in a given route, except that host and path
+// can share variables. They can be retrieved calling mux.Vars(request).
 func (r *Route) Host(tpl string) *Route {
-	r.err = r.addRegexpMatcher(tpl, true, false)
+	r.addError(r.addRegexpMatcher(tpl, true, false))
 	return r
 }
 
@@ -237,8 +732,115 @@ func (r *Route) MatcherFunc(f MatcherFunc) *Route {
 	return r.addMatcher(f)
 }
 
+// Matchers -------------------------------------------------------------------
+
+// Matchers is a reusable set of matchers that can be added to many routes.
+//
+// A set is built chaining the same methods used to add matchers to a route,
+// and each method returns a new set, so a set can be extended without
+// changing the original:
+//
+//     api := mux.Matchers{}.Host("api.domain.com").Schemes("https").
+//         Headers("X-Requested-With", "XMLHttpRequest")
+//     r := mux.NewRouter()
+//     r.HandleFunc("/users", UsersHandler).ApplyMatchers(api)
+//     r.HandleFunc("/orders", OrdersHandler).ApplyMatchers(api).
+//         Methods("POST")
+//
+// The matchers compose with the ones defined for each route: a request must
+// match all of them.
+type Matchers []func(*Route) *Route
+
+// add returns a copy of the set with f appended.
+func (m Matchers) add(f func(*Route) *Route) Matchers {
+	return append(m[:len(m):len(m)], f)
+}
+
+// BodyContentType adds a matcher for the media type of the request body.
+// See Route.BodyContentType().
+func (m Matchers) BodyContentType(types ...string) Matchers {
+	return m.add(func(r *Route) *Route {
+		return r.BodyContentType(append([]string(nil), types...)...)
+	})
+}
+
+// Headers adds a matcher for request header values.
+// See Route.Headers().
+func (m Matchers) Headers(pairs ...string) Matchers {
+	return m.add(func(r *Route) *Route { return r.Headers(pairs...) })
+}
+
+// Host adds a matcher for the URL host.
+// See Route.Host().
+func (m Matchers) Host(tpl string) Matchers {
+	return m.add(func(r *Route) *Route { return r.Host(tpl) })
+}
+
+// MatcherFunc adds a custom function to be used as request matcher.
+// See Route.MatcherFunc().
+func (m Matchers) MatcherFunc(f MatcherFunc) Matchers {
+	return m.add(func(r *Route) *Route { return r.MatcherFunc(f) })
+}
+
+// Methods adds a matcher for HTTP methods.
+// See Route.Methods().
+func (m Matchers) Methods(methods ...string) Matchers {
+	return m.add(func(r *Route) *Route {
+		return r.Methods(append([]string(nil), methods...)...)
+	})
+}
+
+// Queries adds a matcher for URL query values.
+// See Route.Queries().
+func (m Matchers) Queries(pairs ...string) Matchers {
+	return m.add(func(r *Route) *Route { return r.Queries(pairs...) })
+}
+
+// RemoteAddrIn adds a matcher for the client address.
+// See Route.RemoteAddrIn().
+func (m Matchers) RemoteAddrIn(cidrs ...string) Matchers {
+	return m.add(func(r *Route) *Route { return r.RemoteAddrIn(cidrs...) })
+}
+
+// Schemes adds a matcher for URL schemes.
+// See Route.Schemes().
+func (m Matchers) Schemes(schemes ...string) Matchers {
+	return m.add(func(r *Route) *Route {
+		return r.Schemes(append([]string(nil), schemes...)...)
+	})
+}
+
+// ApplyMatchers adds all matchers from a set to the route, in the order they
+// were added to the set.
+func (r *Route) ApplyMatchers(m Matchers) *Route {
+	for _, f := range m {
+		f(r)
+	}
+	return r
+}
+
 // Methods --------------------------------------------------------------------
 
+// ErrMethodMismatch is set in RouteMatch.MatchErr when a route matched the
+// request except for the HTTP method.
+var ErrMethodMismatch = errors.New("mux: method mismatch")
+
+// matchIgnoringMethods returns true if all matchers, except for method
+// matchers, match the request. It doesn't change the RouteMatch used by the
+// route.
+func matchIgnoringMethods(req *http.Request, matchers []matcher) bool {
+	var match RouteMatch
+	for _, m := range matchers {
+		if _, ok := m.(methodMatcher); ok {
+			continue
+		}
+		if !m.Match(req, &match) {
+			return false
+		}
+	}
+	return true
+}
+
 // methodMatcher matches the request against HTTP methods.
 type methodMatcher []string
 
@@ -256,6 +858,123 @@ func (r *Route) Methods(methods ...string) *Route {
 	return r.addMatcher(methodMatcher(methods))
 }
 
+// MethodsWithOptions adds a matcher for HTTP methods, like Methods(), and
+// also matches OPTIONS requests, responding to them with 204
+// (http.StatusNoContent) and an Allow header listing the given methods and
+// OPTIONS. For example:
+//
+//     r := mux.NewRouter()
+//     r.HandleFunc("/products", ProductsHandler).
+//       MethodsWithOptions("GET", "POST")
+//
+// The above route sends GET and POST requests to the handler, and answers
+// OPTIONS requests with "Allow: GET, POST, OPTIONS".
+//
+// If OPTIONS is one of the given methods, OPTIONS requests are sent to the
+// route handler, like for Methods().
+func (r *Route) MethodsWithOptions(methods ...string) *Route {
+	for k, v := range methods {
+		methods[k] = strings.ToUpper(v)
+	}
+	if matchInArray(methods, "OPTIONS") {
+		return r.addMatcher(methodMatcher(methods))
+	}
+	matched := make([]string, len(methods), len(methods)+1)
+	copy(matched, methods)
+	matched = append(matched, "OPTIONS")
+	r.allowMethods = matched
+	return r.addMatcher(methodMatcher(matched))
+}
+
+// allowHandler returns a handler that responds with 204 and an Allow header
+// listing the given methods.
+func allowHandler(methods []string) http.Handler {
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// XHR ------------------------------------------------------------------------
+
+// xhrMatcher matches XMLHttpRequest (AJAX) requests.
+type xhrMatcher struct{}
+
+func (m xhrMatcher) Match(r *http.Request, match *RouteMatch) bool {
+	return strings.EqualFold(r.Header.Get("X-Requested-With"), "XMLHttpRequest")
+}
+
+// XHR adds a matcher for XMLHttpRequest (AJAX) requests.
+//
+// The route will only match if the X-Requested-With header is
+// "XMLHttpRequest". The comparison is case-insensitive.
+func (r *Route) XHR() *Route {
+	return r.addMatcher(xhrMatcher{})
+}
+
+// VarValidator ---------------------------------------------------------------
+
+// VarValidator adds a custom validator for a route variable.
+//
+// After the host or path matches, the value for the variable is passed to
+// fn. If fn returns false the route doesn't match, and the request is
+// tested against the next routes as usual. For example:
+//
+//     r := mux.NewRouter()
+//     r.HandleFunc("/archive/{date:[0-9]{4}-[0-9]{2}-[0-9]{2}}", ArchiveHandler).
+//       VarValidator("date", func(s string) bool {
+//           _, err := time.Parse("2006-01-02", s)
+//           return err == nil
+//       })
+//
+// The above route doesn't match "/archive/2012-02-30". Validators for
+// variables not defined in the route are ignored.
+func (r *Route) VarValidator(name string, fn func(string) bool) *Route {
+	if r.validators == nil {
+		r.validators = make(map[string]func(string) bool)
+	}
+	r.validators[name] = fn
+	return r
+}
+
+// VarDefault -----------------------------------------------------------------
+
+// VarDefault sets a default value for a route variable, used to build URLs
+// when a value is not provided. See Route.URL().
+//
+// A variable can appear in both the host and path of a route, and a single
+// value fills both:
+//
+//     r := mux.NewRouter()
+//     r.Host("{lang}.domain.com").Path("/{lang}/articles").
+//       VarDefault("lang", "en").Name("articles")
+//
+//     // "http://en.domain.com/en/articles"
+//     url, err := r.Get("articles").URL()
+//
+// Defaults are not used for matching.
+func (r *Route) VarDefault(name, value string) *Route {
+	if r.defaults == nil {
+		r.defaults = make(map[string]string)
+	}
+	r.defaults[name] = value
+	return r
+}
+
+// urlPairs returns the key/value pairs to build a URL, preceded by the
+// default values, if any.
+func (r *Route) urlPairs(pairs []string) []string {
+	if r.defaults == nil {
+		return pairs
+	}
+	all := make([]string, 0, len(r.defaults)*2+len(pairs))
+	for k, v := range r.defaults {
+		all = append(all, k, v)
+	}
+	return append(all, pairs...)
+}
+
 // Path -----------------------------------------------------------------------
 
 // Path adds a matcher for the URL path.
@@ -277,7 +996,7 @@ func (r *Route) Methods(methods ...string) *Route {
 // Variable names must be unique in a given route. They can be retrieved
 // calling mux.Vars(request).
 func (r *Route) Path(tpl string) *Route {
-	r.err = r.addRegexpMatcher(tpl, false, false)
+	r.addError(r.addRegexpMatcher(tpl, false, false))
 	return r
 }
 
@@ -286,10 +1005,46 @@ func (r *Route) Path(tpl string) *Route {
 // PathPrefix adds a matcher for the URL path prefix.
 func (r *Route) PathPrefix(tpl string) *Route {
 	r.strictSlash = false
-	r.err = r.addRegexpMatcher(tpl, false, true)
+	r.slashPolicy = SlashOff
+	r.addError(r.addRegexpMatcher(tpl, false, true))
+	return r
+}
+
+// Port -----------------------------------------------------------------------
+
+// Port adds a matcher for the URL port.
+// It accepts a template with zero or more URL variables enclosed by {}, like
+// Route.Host(). A variable without a pattern matches digits. For example:
+//
+//     r := mux.NewRouter()
+//     r.Port("8443")
+//     r.Port("{port:80[0-9]{2}}")
+//
+// If the request doesn't set a port, the default port for the scheme is
+// used: 80 for http and 443 for https.
+//
+// Port variables can be retrieved calling mux.Vars(request), and are added
+// to URLs built for routes with a host. See Route.URL().
+func (r *Route) Port(tpl string) *Route {
+	r.addError(r.addPortMatcher(tpl))
 	return r
 }
 
+// addPortMatcher adds a matcher for the URL port.
+func (r *Route) addPortMatcher(tpl string) error {
+	r.regexp = r.getRegexpGroup()
+	rr, err := r.newRegexp(tpl, regexpTypePort, false, false)
+	if err != nil {
+		return err
+	}
+	if err = uniqueVars(rr.varsN, r.regexp.varNames()); err != nil {
+		return err
+	}
+	r.regexp.port = rr
+	r.addMatcher(rr)
+	return nil
+}
+
 // Query ----------------------------------------------------------------------
 
 // queryMatcher matches the request against URL queries.
@@ -309,15 +1064,87 @@ func (m queryMatcher) Match(r *http.Request, match *RouteMatch) bool {
 // values, e.g.: ?foo=bar&baz=ding.
 //
 // It the value is an empty string, it will match any value if the key is set.
+//
+// Values can also be templates with variables, like in Route.Path():
+//
+//     r := mux.NewRouter()
+//     r.Queries("page", "{page:[0-9]+}")
+//
+// The variables are retrieved calling mux.Vars(request), and the values are
+// added to the query of URLs built for the route, together with the literal
+// values. A route matches if any value for the key matches the template.
 func (r *Route) Queries(pairs ...string) *Route {
-	if r.err == nil {
-		var queries map[string]string
-		queries, r.err = mapFromPairs(pairs...)
-		return r.addMatcher(queryMatcher(queries))
+	queries, err := mapFromPairs(pairs...)
+	if err != nil {
+		r.addError(err)
+		return r
+	}
+	for k, v := range queries {
+		if strings.Contains(v, "{") {
+			if err = r.addQueryMatcher(k, v); err != nil {
+				r.addError(err)
+				return r
+			}
+			delete(queries, k)
+		}
+	}
+	if len(queries) > 0 {
+		r.addMatcher(queryMatcher(queries))
 	}
 	return r
 }
 
+// RemoteAddrIn ---------------------------------------------------------------
+
+// remoteAddrMatcher matches the request against client networks.
+type remoteAddrMatcher struct {
+	nets              []*net.IPNet
+	trustForwardedFor bool
+}
+
+func (m remoteAddrMatcher) Match(r *http.Request, match *RouteMatch) bool {
+	addr := r.RemoteAddr
+	if m.trustForwardedFor {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			addr = fwd[strings.LastIndex(fwd, ",")+1:]
+		}
+	}
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range m.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteAddrIn adds a matcher for the client address.
+// It accepts a sequence of one or more networks in CIDR notation, e.g.:
+// "10.0.0.0/8", "192.168.0.0/16", "::1/128".
+//
+// The client address is read from the request RemoteAddr, or from the
+// X-Forwarded-For header if the router trusts it. See
+// Router.TrustForwardedFor().
+func (r *Route) RemoteAddrIn(cidrs ...string) *Route {
+	m := remoteAddrMatcher{trustForwardedFor: r.trustForwardedFor}
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			r.addError(fmt.Errorf("mux: invalid network %q: %v", cidr, err))
+			return r
+		}
+		m.nets = append(m.nets, n)
+	}
+	return r.addMatcher(m)
+}
+
 // Schemes --------------------------------------------------------------------
 
 // schemeMatcher matches the request against URL schemes.
@@ -351,7 +1178,12 @@ func (r *Route) Schemes(schemes ...string) *Route {
 // Here, the routes registered in the subrouter won't be tested if the host
 // doesn't match.
 func (r *Route) Subrouter() *Router {
-	router := &Router{parent: r, strictSlash: r.strictSlash}
+	if p, ok := r.parent.(*Router); ok && p.frozen {
+		panic("mux: can't register a subrouter, the router is frozen")
+	}
+	router := &Router{parent: r, strictSlash: r.strictSlash,
+		slashPolicy: r.slashPolicy, regexpFlags: r.regexpFlags,
+		varConverters: r.varConverters, trustForwardedFor: r.trustForwardedFor}
 	r.addMatcher(router)
 	return router
 }
@@ -395,6 +1227,7 @@ func (r *Route) URL(pairs ...string) (*url.URL, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
+	pairs = r.urlPairs(pairs)
 	if r.regexp == nil {
 		return nil, errors.New("mux: route doesn't have a host or path")
 	}
@@ -403,7 +1236,7 @@ func (r *Route) URL(pairs ...string) (*url.URL, error) {
 	if r.regexp.host != nil {
 		// Set a default scheme.
 		scheme = "http"
-		if host, err = r.regexp.host.url(pairs...); err != nil {
+		if host, err = r.regexp.hostURL(pairs...); err != nil {
 			return nil, err
 		}
 	}
@@ -412,13 +1245,42 @@ func (r *Route) URL(pairs ...string) (*url.URL, error) {
 			return nil, err
 		}
 	}
+	query, err := r.regexp.queryURL(r.queryValues(), pairs...)
+	if err != nil {
+		return nil, err
+	}
 	return &url.URL{
-		Scheme: scheme,
-		Host:   host,
-		Path:   path,
+		Scheme:   scheme,
+		Host:     host,
+		Path:     path,
+		RawQuery: query,
 	}, nil
 }
 
+// queryValues returns the literal values defined with Route.Queries() for
+// the route and its parent routes. Keys matching any value have an empty
+// value.
+func (r *Route) queryValues() url.Values {
+	query := url.Values{}
+	for route := r; route != nil; {
+		for _, m := range route.matchers {
+			if q, ok := m.(queryMatcher); ok {
+				for k, v := range q {
+					if query[k] == nil {
+						query.Set(k, v)
+					}
+				}
+			}
+		}
+		router, ok := route.parent.(*Router)
+		if !ok {
+			break
+		}
+		route, _ = router.parent.(*Route)
+	}
+	return query
+}
+
 // URLHost builds the host part of the URL for a route. See Route.URL().
 //
 // The route must have a host defined.
@@ -426,10 +1288,11 @@ func (r *Route) URLHost(pairs ...string) (*url.URL, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
+	pairs = r.urlPairs(pairs)
 	if r.regexp == nil || r.regexp.host == nil {
 		return nil, errors.New("mux: route doesn't have a host")
 	}
-	host, err := r.regexp.host.url(pairs...)
+	host, err := r.regexp.hostURL(pairs...)
 	if err != nil {
 		return nil, err
 	}
@@ -446,6 +1309,7 @@ func (r *Route) URLPath(pairs ...string) (*url.URL, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
+	pairs = r.urlPairs(pairs)
 	if r.regexp == nil || r.regexp.path == nil {
 		return nil, errors.New("mux: route doesn't have a path")
 	}
@@ -458,6 +1322,58 @@ func (r *Route) URLPath(pairs ...string) (*url.URL, error) {
 	}, nil
 }
 
+// GetPathTemplate returns the template used to build the route path, as
+// passed to Path() or PathPrefix(). For routes in a subrouter, it includes
+// the path prefix of the parent route.
+//
+// The route must have a path defined.
+func (r *Route) GetPathTemplate() (string, error) {
+	if r.err != nil {
+		return "", r.err
+	}
+	if r.regexp == nil || r.regexp.path == nil {
+		return "", errors.New("mux: route doesn't have a path")
+	}
+	return r.regexp.path.template, nil
+}
+
+// GetHostTemplate returns the template used to build the route host, as
+// passed to Host().
+//
+// The route must have a host defined.
+func (r *Route) GetHostTemplate() (string, error) {
+	if r.err != nil {
+		return "", r.err
+	}
+	if r.regexp == nil || r.regexp.host == nil {
+		return "", errors.New("mux: route doesn't have a host")
+	}
+	return r.regexp.host.template, nil
+}
+
+// ----------------------------------------------------------------------------
+// MultiError
+// ----------------------------------------------------------------------------
+
+// MultiError stores multiple errors resulted from building a route.
+type MultiError []error
+
+func (e MultiError) Error() string {
+	s := ""
+	if len(e) > 0 {
+		s = e[0].Error()
+	}
+	switch len(e) {
+	case 0:
+		return "(0 errors)"
+	case 1:
+		return s
+	case 2:
+		return s + " (and 1 other error)"
+	}
+	return fmt.Sprintf("%s (and %d other errors)", s, len(e)-1)
+}
+
 // ----------------------------------------------------------------------------
 // parentRoute
 // ----------------------------------------------------------------------------
@@ -490,8 +1406,11 @@ func (r *Route) getRegexpGroup() *routeRegexpGroup {
 		} else {
 			// Copy.
 			r.regexp = &routeRegexpGroup{
-				host: regexp.host,
-				path: regexp.path,
+				host:    regexp.host,
+				path:    regexp.path,
+				port:    regexp.port,
+				queries: append([]*routeRegexp(nil), regexp.queries...),
+				shared:  regexp.shared,
 			}
 		}
 	}
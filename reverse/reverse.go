@@ -95,15 +95,9 @@ func (r *Regexp) Values(s string) url.Values {
 //
 // The values are modified in place, and only the unused ones are left.
 func (r *Regexp) Revert(values url.Values) (string, error) {
-	vars := make([]interface{}, len(r.groups))
-	for k, v := range r.groups {
-		if len(values[v]) == 0 {
-			return "", fmt.Errorf(
-				"Missing key %q to revert the regexp "+
-					"(expected a total of %d variables)", v, len(r.groups))
-		}
-		vars[k] = values[v][0]
-		values[v] = values[v][1:]
+	vars, err := r.vars(values)
+	if err != nil {
+		return "", err
 	}
 	return fmt.Sprintf(r.template, vars...), nil
 }
@@ -124,6 +118,45 @@ func (r *Regexp) RevertValid(values url.Values) (string, error) {
 	return reverse, nil
 }
 
+// RevertEscaped is the same as RevertValid but it percent-escapes the values
+// to be used as URL path segments. For example, "a b/c" becomes "a%20b%2Fc".
+//
+// The string is validated against the compiled regexp before the values
+// are escaped.
+//
+// The values are modified in place, and only the unused ones are left.
+func (r *Regexp) RevertEscaped(values url.Values) (string, error) {
+	vars, err := r.vars(values)
+	if err != nil {
+		return "", err
+	}
+	if reverse := fmt.Sprintf(r.template, vars...); !r.compiled.MatchString(reverse) {
+		return "", fmt.Errorf("Resulting string doesn't match the regexp: %q",
+			reverse)
+	}
+	for k, v := range vars {
+		vars[k] = url.PathEscape(v.(string))
+	}
+	return fmt.Sprintf(r.template, vars...), nil
+}
+
+// vars returns the values to fill the reverse template, in order.
+//
+// The values are modified in place, and only the unused ones are left.
+func (r *Regexp) vars(values url.Values) ([]interface{}, error) {
+	vars := make([]interface{}, len(r.groups))
+	for k, v := range r.groups {
+		if len(values[v]) == 0 {
+			return nil, fmt.Errorf(
+				"Missing key %q to revert the regexp "+
+					"(expected a total of %d variables)", v, len(r.groups))
+		}
+		vars[k] = values[v][0]
+		values[v] = values[v][1:]
+	}
+	return vars, nil
+}
+
 // template builds a reverse template for a regexp.
 type template struct {
 	buffer *bytes.Buffer
@@ -121,6 +121,37 @@ func TestReverseRegexp(t *testing.T) {
 	}
 }
 
+func TestRevertEscaped(t *testing.T) {
+	tests := []struct {
+		pattern string
+		values  url.Values
+		result  string
+		valid   bool
+	}{
+		{`^/users/(?P<name>.+)$`, url.Values{"name": {"john doe"}}, "/users/john%20doe", true},
+		{`^/users/(?P<name>.+)$`, url.Values{"name": {"a/b?c#d%e"}}, "/users/a%2Fb%3Fc%23d%25e", true},
+		{`^/(?P<name>[a-z ]+)/(\d+)$`, url.Values{"name": {"a b"}, "": {"1"}}, "/a%20b/1", true},
+		{`^/(?P<id>\d+)$`, url.Values{"id": {"1 2"}}, "", false},
+		{`^/(?P<id>\d+)$`, url.Values{}, "", false},
+	}
+	for _, test := range tests {
+		r, err := CompileRegexp(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reverted, err := r.RevertEscaped(copyValues(test.values))
+		if test.valid {
+			if err != nil {
+				t.Errorf("%q: expected success on RevertEscaped, got %v", test.pattern, err)
+			} else if reverted != test.result {
+				t.Errorf("%q: expected reverted %q, got %q for values %v", test.pattern, test.result, reverted, test.values)
+			}
+		} else if err == nil {
+			t.Errorf("%q: expected error on RevertEscaped", test.pattern)
+		}
+	}
+}
+
 type groupTest struct {
 	pattern string
 	groups  []string
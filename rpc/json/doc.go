@@ -38,7 +38,9 @@ Request format is:
 		An array with a single object to pass as argument to the method.
 	id:
 		The request id, a uint. It is used to match the response with the
-		request that it is replying to.
+		request that it is replying to. Requests without an id or with a
+		null id are notifications: they get an empty response with the
+		status 204 No Content.
 
 Response format is:
 
@@ -51,6 +53,19 @@ Response format is:
 	id:
 		The same id as the request it is responding to.
 
+Functions can be registered in the codec to observe each call, e.g. for
+logging or metrics. They receive a RequestInfo with the method name, the
+args, the reply, the error and the HTTP request:
+
+	c := json.NewCodec()
+	c.RegisterAfterFunc(func(i *json.RequestInfo) {
+		log.Printf("%s: %v", i.Method, i.Error)
+	})
+
+Functions registered with RegisterBeforeFunc are called after the method
+and args were read, and functions registered with RegisterAfterFunc are
+called when the response is written.
+
 Check the gorilla/rpc documentation for more details:
 
 	http://gorilla-web.appspot.com/pkg/rpc
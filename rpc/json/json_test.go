@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"errors"
 	"net/http"
+	"reflect"
 	"testing"
 
 	"code.google.com/p/gorilla/rpc"
@@ -121,3 +122,96 @@ func TestService(t *testing.T) {
 		t.Errorf("Expected to get %q, but got %q", ErrResponseError, err)
 	}
 }
+
+func TestNotification(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	s.RegisterService(new(Service1), "")
+
+	tests := []struct {
+		body   string
+		code   int
+		result string
+	}{
+		// Notifications don't have a response.
+		{`{"method":"Service1.Multiply","params":[{"A":4,"B":2}]}`, 204, ""},
+		{`{"method":"Service1.Multiply","params":[{"A":4,"B":2}],"id":null}`, 204, ""},
+		// Requests with an id always get a JSON response, even for null
+		// results.
+		{`{"method":"Service1.Multiply","params":[{"A":4,"B":2}],"id":1}`, 200,
+			`{"result":{"Result":8},"error":null,"id":1}` + "\n"},
+		{`{"method":"Service1.ResponseError","params":[{"A":4,"B":2}],"id":"a"}`, 200,
+			`{"result":null,"error":"response error","id":"a"}` + "\n"},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", "http://localhost:8080/", bytes.NewBufferString(test.body))
+		r.Header.Set("Content-Type", "application/json")
+		w := NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.body, test.code, w.Code)
+		}
+		if w.Body.String() != test.result {
+			t.Errorf("%s: expected body %q, got %q", test.body, test.result, w.Body.String())
+		}
+		ct := w.HeaderMap.Get("Content-Type")
+		if test.code == 204 && ct != "" {
+			t.Errorf("%s: unexpected Content-Type %q", test.body, ct)
+		} else if test.code == 200 && ct != "application/json; charset=utf-8" {
+			t.Errorf("%s: wrong Content-Type %q", test.body, ct)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var calls []string
+	var before, after *RequestInfo
+	c := NewCodec()
+	c.RegisterBeforeFunc(func(i *RequestInfo) {
+		calls = append(calls, "before "+i.Method)
+		before = &RequestInfo{Method: i.Method, Args: i.Args, Request: i.Request}
+	})
+	c.RegisterAfterFunc(func(i *RequestInfo) {
+		calls = append(calls, "after "+i.Method)
+		after = i
+	})
+	s := rpc.NewServer()
+	s.RegisterCodec(c, "application/json")
+	s.RegisterService(new(Service1), "")
+
+	var res Service1Response
+	if err := execute(t, s, "Service1.Multiply", &Service1Request{4, 2}, &res); err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+	expected := []string{"before Service1.Multiply", "after Service1.Multiply"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, calls)
+	}
+	if args, ok := before.Args.(*Service1Request); !ok || args.A != 4 || args.B != 2 {
+		t.Errorf("Wrong args in before hook: %#v", before.Args)
+	}
+	if before.Request == nil || before.Request.Method != "POST" {
+		t.Errorf("Expected the HTTP request in before hook, got %v", before.Request)
+	}
+	if reply, ok := after.Reply.(*Service1Response); !ok || reply.Result != 8 {
+		t.Errorf("Wrong reply in after hook: %#v", after.Reply)
+	}
+	if after.Error != nil {
+		t.Errorf("Expected nil error in after hook, got %v", after.Error)
+	}
+
+	calls = nil
+	execute(t, s, "Service1.ResponseError", &Service1Request{4, 2}, &res)
+	if after.Error != ErrResponseError {
+		t.Errorf("Expected %q in after hook, got %v", ErrResponseError, after.Error)
+	}
+
+	// Hooks are not called if the request can't be read.
+	calls = nil
+	r, _ := http.NewRequest("POST", "http://localhost:8080/", bytes.NewBufferString(`{"method":`))
+	r.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(NewRecorder(), r)
+	if len(calls) != 0 {
+		t.Errorf("Expected no calls for an invalid request, got %v", calls)
+	}
+}
@@ -52,11 +52,47 @@ func NewCodec() *Codec {
 
 // Codec creates a CodecRequest to process each request.
 type Codec struct {
+	beforeFuncs []func(*RequestInfo)
+	afterFuncs  []func(*RequestInfo)
 }
 
 // NewRequest returns a CodecRequest.
 func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
-	return newCodecRequest(r)
+	return newCodecRequest(c, r)
+}
+
+// RegisterBeforeFunc adds a function to be called before each RPC method,
+// once the method name and the args were read from the request.
+//
+// Functions are called in the order they were registered. They must be
+// registered before the codec is used to serve requests.
+func (c *Codec) RegisterBeforeFunc(f func(i *RequestInfo)) {
+	c.beforeFuncs = append(c.beforeFuncs, f)
+}
+
+// RegisterAfterFunc adds a function to be called after each RPC method,
+// when the response is written. The RequestInfo has the reply and the
+// error returned by the method.
+//
+// Functions are called in the order they were registered. They must be
+// registered before the codec is used to serve requests.
+func (c *Codec) RegisterAfterFunc(f func(i *RequestInfo)) {
+	c.afterFuncs = append(c.afterFuncs, f)
+}
+
+// RequestInfo describes a RPC call. It is passed to the functions
+// registered with RegisterBeforeFunc and RegisterAfterFunc.
+type RequestInfo struct {
+	// The RPC method, as in "Service.Method".
+	Method string
+	// The args decoded from the request.
+	Args interface{}
+	// The reply of the method. It is nil for before functions.
+	Reply interface{}
+	// The error returned by the method. It is nil for before functions.
+	Error error
+	// The HTTP request.
+	Request *http.Request
 }
 
 // ----------------------------------------------------------------------------
@@ -64,18 +100,25 @@ func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
 // ----------------------------------------------------------------------------
 
 // newCodecRequest returns a new CodecRequest.
-func newCodecRequest(r *http.Request) rpc.CodecRequest {
+func newCodecRequest(codec *Codec, r *http.Request) rpc.CodecRequest {
 	// Decode the request body and check if RPC method is valid.
 	req := new(serverRequest)
 	err := json.NewDecoder(r.Body).Decode(req)
 	r.Body.Close()
-	return &CodecRequest{request: req, err: err}
+	return &CodecRequest{
+		codec:   codec,
+		request: req,
+		err:     err,
+		info:    &RequestInfo{Method: req.Method, Request: r},
+	}
 }
 
 // CodecRequest decodes and encodes a single request.
 type CodecRequest struct {
+	codec   *Codec
 	request *serverRequest
 	err     error
+	info    *RequestInfo
 }
 
 // Method returns the RPC method for the current request.
@@ -96,6 +139,12 @@ func (c *CodecRequest) ReadRequest(args interface{}) error {
 		params := [1]interface{}{args}
 		c.err = json.Unmarshal(*c.request.Params, &params)
 	}
+	if c.err == nil {
+		c.info.Args = args
+		for _, f := range c.codec.beforeFuncs {
+			f(c.info)
+		}
+	}
 	return c.err
 }
 
@@ -103,10 +152,22 @@ func (c *CodecRequest) ReadRequest(args interface{}) error {
 //
 // The err parameter is the error resulted from calling the RPC method,
 // or nil if there was no error.
+//
+// Notifications, requests without an id, don't have a response: the status
+// 204 No Content is written without a body.
 func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}, methodErr error) error {
 	if c.err != nil {
 		return c.err
 	}
+	c.info.Reply = reply
+	c.info.Error = methodErr
+	for _, f := range c.codec.afterFuncs {
+		f(c.info)
+	}
+	if c.request.Id == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
 	res := &serverResponse{
 		Result: reply,
 		Error:  &null,
@@ -119,13 +180,6 @@ func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}, m
 		// http://json-rpc.org/wiki/specification#a1.2Response
 		res.Result = &null
 	}
-	if c.request.Id == nil {
-		// Id is null for notifications and they don't have a response.
-		res.Id = &null
-	} else {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		encoder := json.NewEncoder(w)
-		encoder.Encode(res)
-	}
-	return nil
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(res)
 }
@@ -0,0 +1,83 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package gorilla/rpc/json2 provides a codec for JSON-RPC 2.0 over HTTP services.
+
+To register the codec in a RPC server:
+
+	import (
+		"http"
+		"code.google.com/p/gorilla/rpc"
+		"code.google.com/p/gorilla/rpc/json2"
+	)
+
+	func init() {
+		s := rpc.NewServer()
+		s.RegisterCodec(json2.NewCodec(), "application/json")
+		// [...]
+		http.Handle("/rpc", s)
+	}
+
+A codec is tied to a content type. To serve both JSON-RPC versions from the
+same server, register this codec and the gorilla/rpc/json codec using
+different content types.
+
+This package follows the JSON-RPC 2.0 specification:
+
+	http://www.jsonrpc.org/specification
+
+Request format is:
+
+	jsonrpc:
+		The protocol version, which must be exactly "2.0".
+	method:
+		The name of the method to be invoked, as a string in dotted notation
+		as in "Service.Method".
+	params:
+		An array with a single object, or an object whose members are the
+		fields of the method argument.
+	id:
+		The request id. It is used to match the response with the request
+		that it is replying to. Requests without an id member are
+		notifications: they get an empty response with the status
+		204 No Content.
+
+Response format is:
+
+	jsonrpc:
+		The protocol version, "2.0".
+	result:
+		The Object that was returned by the invoked method. It is not
+		present in case there was an error invoking the method.
+	error:
+		An Error object if there was an error invoking the method. It is
+		not present if there was no error.
+	id:
+		The same id as the request it is responding to.
+
+An Error object has a numeric code, a message and optional data. Service
+methods can return an *Error to set them; other errors are sent with the
+E_SERVER code and the error message:
+
+	func (s *HelloService) Say(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+		if args.Who == "" {
+			return &json2.Error{
+				Code:    json2.E_BAD_PARAMS,
+				Message: "who is required",
+			}
+		}
+		// [...]
+	}
+
+Requests that can't be served, e.g. with invalid JSON or for a method that
+is not registered, get the status 400 and an error response with one of
+the codes defined by the specification.
+
+Check the gorilla/rpc documentation for more details:
+
+	http://gorilla-web.appspot.com/pkg/rpc
+*/
+package json2
@@ -0,0 +1,239 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json2
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gorilla/rpc"
+)
+
+// ResponseRecorder is an implementation of http.ResponseWriter that
+// records its mutations for later inspection in tests.
+type ResponseRecorder struct {
+	Code      int           // the HTTP response code from WriteHeader
+	HeaderMap http.Header   // the HTTP response headers
+	Body      *bytes.Buffer // if non-nil, the bytes.Buffer to append written data to
+	Flushed   bool
+}
+
+// NewRecorder returns an initialized ResponseRecorder.
+func NewRecorder() *ResponseRecorder {
+	return &ResponseRecorder{
+		HeaderMap: make(http.Header),
+		Body:      new(bytes.Buffer),
+	}
+}
+
+// DefaultRemoteAddr is the default remote address to return in RemoteAddr if
+// an explicit DefaultRemoteAddr isn't set on ResponseRecorder.
+const DefaultRemoteAddr = "1.2.3.4"
+
+// Header returns the response headers.
+func (rw *ResponseRecorder) Header() http.Header {
+	return rw.HeaderMap
+}
+
+// Write always succeeds and writes to rw.Body, if not nil.
+func (rw *ResponseRecorder) Write(buf []byte) (int, error) {
+	if rw.Body != nil {
+		rw.Body.Write(buf)
+	}
+	if rw.Code == 0 {
+		rw.Code = http.StatusOK
+	}
+	return len(buf), nil
+}
+
+// WriteHeader sets rw.Code.
+func (rw *ResponseRecorder) WriteHeader(code int) {
+	rw.Code = code
+}
+
+// Flush sets rw.Flushed to true.
+func (rw *ResponseRecorder) Flush() {
+	rw.Flushed = true
+}
+
+// ----------------------------------------------------------------------------
+
+var ErrResponseError = errors.New("response error")
+
+type Service1Request struct {
+	A int
+	B int
+}
+
+type Service1Response struct {
+	Result int
+}
+
+type Service1 struct {
+}
+
+func (t *Service1) Multiply(r *http.Request, req *Service1Request, res *Service1Response) error {
+	res.Result = req.A * req.B
+	return nil
+}
+
+func (t *Service1) ResponseError(r *http.Request, req *Service1Request, res *Service1Response) error {
+	return ErrResponseError
+}
+
+func (t *Service1) CodeError(r *http.Request, req *Service1Request, res *Service1Response) error {
+	return &Error{Code: E_BAD_PARAMS, Message: "bad params", Data: req.A}
+}
+
+func newServer() *rpc.Server {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	s.RegisterService(new(Service1), "")
+	return s
+}
+
+func executeRaw(t *testing.T, s *rpc.Server, body string) *ResponseRecorder {
+	r, _ := http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	w := NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func execute(t *testing.T, s *rpc.Server, method string, req, res interface{}) error {
+	if !s.HasMethod(method) {
+		t.Fatal("Expected to be registered:", method)
+	}
+
+	buf, _ := EncodeClientRequest(method, req)
+	w := executeRaw(t, s, string(buf))
+	return DecodeClientResponse(w.Body, res)
+}
+
+func TestService(t *testing.T) {
+	s := newServer()
+
+	var res Service1Response
+	if err := execute(t, s, "Service1.Multiply", &Service1Request{4, 2}, &res); err != nil {
+		t.Error("Expected err to be nil, but got:", err)
+	}
+	if res.Result != 8 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+
+	err := execute(t, s, "Service1.ResponseError", &Service1Request{4, 2}, &res)
+	if e, ok := err.(*Error); !ok {
+		t.Errorf("Expected to get an *Error, but got %#v", err)
+	} else if e.Code != E_SERVER || e.Message != ErrResponseError.Error() {
+		t.Errorf("Expected to get %q with code %d, but got %#v", ErrResponseError, E_SERVER, e)
+	}
+
+	err = execute(t, s, "Service1.CodeError", &Service1Request{4, 2}, &res)
+	if e, ok := err.(*Error); !ok {
+		t.Errorf("Expected to get an *Error, but got %#v", err)
+	} else if e.Code != E_BAD_PARAMS || e.Message != "bad params" || e.Data != float64(4) {
+		t.Errorf("Unexpected error: %#v", e)
+	}
+}
+
+func TestParams(t *testing.T) {
+	s := newServer()
+	tests := []string{
+		`{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":3,"B":5},"id":1}`,
+		`{"jsonrpc":"2.0","method":"Service1.Multiply","params":[{"A":3,"B":5}],"id":1}`,
+	}
+	for _, body := range tests {
+		w := executeRaw(t, s, body)
+		var res Service1Response
+		if err := DecodeClientResponse(w.Body, &res); err != nil {
+			t.Errorf("%s: unexpected error: %v", body, err)
+		} else if res.Result != 15 {
+			t.Errorf("%s: wrong response: %v.", body, res.Result)
+		}
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	s := newServer()
+	w := executeRaw(t, s, `{"jsonrpc":"2.0","method":"Service1.ResponseError","params":{},"id":"a"}`)
+	expected := `{"jsonrpc":"2.0","error":{"code":-32000,"message":"response error"},"id":"a"}` + "\n"
+	if w.Body.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, w.Body.String())
+	}
+	if ct := w.HeaderMap.Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Wrong Content-Type: %q", ct)
+	}
+
+	// Notifications don't get a response. A null id is not a notification.
+	w = executeRaw(t, s, `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{}}`)
+	if w.Code != 204 || w.Body.Len() != 0 {
+		t.Errorf("Expected 204 without body for notification, got %d %s", w.Code, w.Body.String())
+	}
+	w = executeRaw(t, s, `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":2,"B":3},"id":null}`)
+	expected = `{"jsonrpc":"2.0","result":{"Result":6},"id":null}` + "\n"
+	if w.Code != 200 || w.Body.String() != expected {
+		t.Errorf("Expected %s, got %d %s", expected, w.Code, w.Body.String())
+	}
+}
+
+func TestInvalidRequest(t *testing.T) {
+	s := newServer()
+	tests := []struct {
+		body string
+		code int
+		id   string
+	}{
+		// Invalid JSON.
+		{`{"jsonrpc":"2.0",`, E_PARSE, "null"},
+		// Missing version.
+		{`{"method":"Service1.Multiply","params":{"A":3,"B":5},"id":1}`, E_INVALID_REQ, "null"},
+		// Unknown method.
+		{`{"jsonrpc":"2.0","method":"Service1.Divide","params":{},"id":1}`, E_NO_METHOD, "1"},
+		{`{"jsonrpc":"2.0","method":"Multiply","params":{},"id":"a"}`, E_NO_METHOD, `"a"`},
+		// Wrong params type.
+		{`{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":"3"},"id":2}`, E_BAD_PARAMS, "2"},
+	}
+	for _, test := range tests {
+		w := executeRaw(t, s, test.body)
+		if w.Code != 400 {
+			t.Errorf("%s: expected status 400, got %d", test.body, w.Code)
+		}
+		if ct := w.HeaderMap.Get("Content-Type"); ct != "application/json; charset=utf-8" {
+			t.Errorf("%s: wrong Content-Type: %q", test.body, ct)
+		}
+		var res struct {
+			Version string          `json:"jsonrpc"`
+			Error   *Error          `json:"error"`
+			Id      json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Errorf("%s: invalid response %q: %v", test.body, w.Body.String(), err)
+			continue
+		}
+		if res.Version != Version || res.Error == nil || res.Error.Code != test.code ||
+			res.Error.Message == "" {
+			t.Errorf("%s: expected error code %d, got %s", test.body, test.code, w.Body.String())
+		}
+		if id := string(res.Id); id != test.id {
+			t.Errorf("%s: expected id %s, got %s", test.body, test.id, id)
+		}
+	}
+
+	// Notifications don't get errors for unknown methods or invalid params.
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"Service1.Divide","params":{}}`,
+		`{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":"3"}}`,
+	} {
+		if w := executeRaw(t, s, body); w.Code != 204 || w.Body.Len() != 0 {
+			t.Errorf("%s: expected 204 without body, got %d %s", body, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"code.google.com/p/gorilla/rpc"
+)
+
+// Version is the JSON-RPC version implemented by this package.
+const Version = "2.0"
+
+var null = json.RawMessage([]byte("null"))
+
+// ----------------------------------------------------------------------------
+// Request and Response
+// ----------------------------------------------------------------------------
+
+// serverRequest represents a JSON-RPC request received by the server.
+type serverRequest struct {
+	// A String specifying the version of the JSON-RPC protocol.
+	// It must be exactly "2.0".
+	Version string `json:"jsonrpc"`
+	// A String containing the name of the method to be invoked.
+	Method string `json:"method"`
+	// An Array or Object to pass as arguments to the method.
+	Params *json.RawMessage `json:"params"`
+	// The request id. This can be of any type. It is used to match the
+	// response with the request that it is replying to. It is empty for
+	// notifications, which don't have an id member; a null id is "null".
+	Id json.RawMessage `json:"id"`
+}
+
+// serverResponse represents a JSON-RPC response returned by the server.
+type serverResponse struct {
+	// A String specifying the version of the JSON-RPC protocol.
+	Version string `json:"jsonrpc"`
+	// The Object that was returned by the invoked method. It must not be
+	// present in case there was an error invoking the method.
+	Result interface{} `json:"result,omitempty"`
+	// An Error object if there was an error invoking the method. It must
+	// not be present if there was no error.
+	Error *Error `json:"error,omitempty"`
+	// This must be the same id as the request it is responding to.
+	Id *json.RawMessage `json:"id"`
+}
+
+// ----------------------------------------------------------------------------
+// Error
+// ----------------------------------------------------------------------------
+
+// Error codes defined by the JSON-RPC 2.0 specification.
+const (
+	E_PARSE       = -32700
+	E_INVALID_REQ = -32600
+	E_NO_METHOD   = -32601
+	E_BAD_PARAMS  = -32602
+	E_INTERNAL    = -32603
+	E_SERVER      = -32000
+)
+
+// Error is a JSON-RPC error object.
+//
+// Service methods can return an *Error to set a precise code and data for
+// the response. Other errors are sent with the E_SERVER code and their
+// message.
+//
+// Requests that can't be served get an error with the E_PARSE,
+// E_INVALID_REQ, E_NO_METHOD, E_BAD_PARAMS or E_INTERNAL code.
+type Error struct {
+	// A Number that indicates the error type that occurred.
+	Code int `json:"code"`
+	// A String providing a short description of the error.
+	Message string `json:"message"`
+	// A Primitive or Structured value that contains additional information
+	// about the error. It may be omitted.
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ----------------------------------------------------------------------------
+// Codec
+// ----------------------------------------------------------------------------
+
+// NewCodec returns a new JSON-RPC 2.0 Codec.
+func NewCodec() *Codec {
+	return &Codec{}
+}
+
+// Codec creates a CodecRequest to process each request.
+type Codec struct {
+}
+
+// NewRequest returns a CodecRequest.
+func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
+	return newCodecRequest(r)
+}
+
+// ----------------------------------------------------------------------------
+// CodecRequest
+// ----------------------------------------------------------------------------
+
+// newCodecRequest returns a new CodecRequest.
+func newCodecRequest(r *http.Request) rpc.CodecRequest {
+	// Decode the request body and check if RPC method is valid.
+	req := new(serverRequest)
+	var err error
+	if errDecode := json.NewDecoder(r.Body).Decode(req); errDecode != nil {
+		err = &Error{Code: E_PARSE, Message: errDecode.Error()}
+	} else if req.Version != Version {
+		err = &Error{
+			Code:    E_INVALID_REQ,
+			Message: `jsonrpc must be "` + Version + `"`,
+		}
+	}
+	r.Body.Close()
+	return &CodecRequest{request: req, err: err}
+}
+
+// CodecRequest decodes and encodes a single request.
+type CodecRequest struct {
+	request *serverRequest
+	err     error
+}
+
+// Method returns the RPC method for the current request.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (c *CodecRequest) Method() (string, error) {
+	if c.err == nil {
+		return c.request.Method, nil
+	}
+	return "", c.err
+}
+
+// ReadRequest fills the request object for the RPC method.
+//
+// Params can be an array with a single element or an object whose members
+// are the fields of the request object. Missing params leave the request
+// object unchanged.
+func (c *CodecRequest) ReadRequest(args interface{}) error {
+	if c.err == nil && c.request.Params != nil {
+		var err error
+		if params := bytes.TrimSpace(*c.request.Params); len(params) > 0 &&
+			params[0] == '[' {
+			// Positional params: unmarshal into array containing the
+			// request struct.
+			array := [1]interface{}{args}
+			err = json.Unmarshal(params, &array)
+		} else {
+			// Named params: unmarshal directly into the request struct.
+			err = json.Unmarshal(params, args)
+		}
+		if err != nil {
+			c.err = &Error{Code: E_BAD_PARAMS, Message: err.Error()}
+		}
+	}
+	return c.err
+}
+
+// WriteResponse encodes the response and writes it to the ResponseWriter.
+//
+// The err parameter is the error resulted from calling the RPC method,
+// or nil if there was no error.
+//
+// Notifications, requests without an id member, don't have a response: the
+// status 204 No Content is written without a body.
+func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}, methodErr error) error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.request.Id == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	res := &serverResponse{
+		Version: Version,
+		Result:  reply,
+		Id:      &c.request.Id,
+	}
+	if methodErr != nil {
+		if jsonErr, ok := methodErr.(*Error); ok {
+			res.Error = jsonErr
+		} else {
+			res.Error = &Error{Code: E_SERVER, Message: methodErr.Error()}
+		}
+		// Result must not be present if there was an error.
+		res.Result = nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(res)
+}
+
+// WriteError writes an error response for a request that can't be served.
+// It implements rpc.CodecErrorWriter.
+//
+// The id is null if the request could not be read. Notifications for a
+// method that is not registered or with invalid params get the status
+// 204 No Content without a body.
+func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	jsonErr, ok := err.(*Error)
+	if !ok {
+		if _, ok := err.(*rpc.MethodError); ok {
+			jsonErr = &Error{Code: E_NO_METHOD, Message: err.Error()}
+		} else {
+			jsonErr = &Error{Code: E_INTERNAL, Message: err.Error()}
+		}
+	}
+	res := &serverResponse{
+		Version: Version,
+		Error:   jsonErr,
+		Id:      &null,
+	}
+	switch jsonErr.Code {
+	case E_PARSE, E_INVALID_REQ:
+	default:
+		if c.request.Id == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		res.Id = &c.request.Id
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
@@ -133,24 +133,34 @@ func (m *serviceMap) register(rcvr interface{}, name string) error {
 func (m *serviceMap) get(method string) (*service, *serviceMethod, error) {
 	parts := strings.Split(method, ".")
 	if len(parts) != 2 {
-		err := fmt.Errorf("rpc: service/method request ill-formed: %q", method)
+		err := &MethodError{method, "rpc: service/method request ill-formed"}
 		return nil, nil, err
 	}
 	m.mutex.Lock()
 	service := m.services[parts[0]]
 	m.mutex.Unlock()
 	if service == nil {
-		err := fmt.Errorf("rpc: can't find service %q", method)
+		err := &MethodError{method, "rpc: can't find service"}
 		return nil, nil, err
 	}
 	serviceMethod := service.methods[parts[1]]
 	if serviceMethod == nil {
-		err := fmt.Errorf("rpc: can't find method %q", method)
+		err := &MethodError{method, "rpc: can't find method"}
 		return nil, nil, err
 	}
 	return service, serviceMethod, nil
 }
 
+// MethodError is the error for a request to a method that is not registered.
+type MethodError struct {
+	Method string // the requested method.
+	msg    string
+}
+
+func (e *MethodError) Error() string {
+	return fmt.Sprintf("%s %q", e.msg, e.Method)
+}
+
 // isExported returns true of a string is an exported (upper case) name.
 func isExported(name string) bool {
 	rune, _ := utf8.DecodeRuneInString(name)
@@ -33,6 +33,15 @@ type CodecRequest interface {
 	WriteResponse(http.ResponseWriter, interface{}, error) error
 }
 
+// CodecErrorWriter can be implemented by a CodecRequest to write errors for
+// requests that can't be served in the format of the codec. Otherwise the
+// server writes the error message as plain text.
+type CodecErrorWriter interface {
+	// Writes the error with the given HTTP status. A *MethodError is
+	// passed if the requested method is not registered.
+	WriteError(http.ResponseWriter, int, error)
+}
+
 // ----------------------------------------------------------------------------
 // Server
 // ----------------------------------------------------------------------------
@@ -111,18 +120,18 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Get service method to be called.
 	method, errMethod := codecReq.Method()
 	if errMethod != nil {
-		writeError(w, 400, errMethod.Error())
+		writeCodecError(w, codecReq, 400, errMethod)
 		return
 	}
 	serviceSpec, methodSpec, errGet := s.services.get(method)
 	if errGet != nil {
-		writeError(w, 400, errGet.Error())
+		writeCodecError(w, codecReq, 400, errGet)
 		return
 	}
 	// Decode the args.
 	args := reflect.New(methodSpec.argsType)
 	if errRead := codecReq.ReadRequest(args.Interface()); errRead != nil {
-		writeError(w, 400, errRead.Error())
+		writeCodecError(w, codecReq, 400, errRead)
 		return
 	}
 	// Call the service method.
@@ -144,7 +153,17 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("x-content-type-options", "nosniff")
 	// Encode the response.
 	if errWrite := codecReq.WriteResponse(w, reply.Interface(), errResult); errWrite != nil {
-		writeError(w, 400, errWrite.Error())
+		writeCodecError(w, codecReq, 400, errWrite)
+	}
+}
+
+// writeCodecError writes an error using the codec request, if it implements
+// CodecErrorWriter, or as plain text.
+func writeCodecError(w http.ResponseWriter, codecReq CodecRequest, status int, err error) {
+	if errWriter, ok := codecReq.(CodecErrorWriter); ok {
+		errWriter.WriteError(w, status, err)
+	} else {
+		writeError(w, status, err.Error())
 	}
 }
 
@@ -0,0 +1,47 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"net/http"
+)
+
+// BindRequest fills a struct from a request using the Default decoder.
+// See Decoder.BindRequest().
+func BindRequest(dst interface{}, r *http.Request, pathVars map[string]string) error {
+	return Default.BindRequest(dst, r, pathVars)
+}
+
+// BindRequest fills a struct merging values from several request sources:
+// the URL query, the POST or PUT body and route variables, typically the
+// result of mux.Vars(request).
+//
+// When a key is present in more than one source, the values from the later
+// source replace the earlier ones. The precedence order, from lowest to
+// highest, is:
+//
+// - URL query values;
+//
+// - form values from the request body;
+//
+// - path variables.
+//
+// It calls r.ParseForm() to parse the request body, if needed.
+func (d *Decoder) BindRequest(dst interface{}, r *http.Request, pathVars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	src := make(map[string][]string)
+	for k, v := range r.URL.Query() {
+		src[k] = v
+	}
+	for k, v := range r.PostForm {
+		src[k] = v
+	}
+	for k, v := range pathVars {
+		src[k] = []string{v}
+	}
+	return d.Decode(dst, src)
+}
@@ -5,6 +5,7 @@
 package schema
 
 import (
+	"encoding"
 	"errors"
 	"reflect"
 	"strconv"
@@ -14,11 +15,24 @@ import (
 
 var invalidPath = errors.New("schema: invalid path")
 
+// unsupportedPath is returned by parsePath for a field with a type that
+// can't be decoded.
+var unsupportedPath = errors.New("schema: unsupported field type")
+
+// ignoredPath is returned by parsePath for a field tagged with "-".
+var ignoredPath = errors.New("schema: ignored field")
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
 // newCache returns a new cache.
 func newCache() *cache {
 	c := cache{
-		m:    make(map[reflect.Type]*structInfo),
-		conv: make(map[reflect.Type]Converter),
+		m:         make(map[cacheKey]*structInfo),
+		conv:      make(map[reflect.Type]Converter),
+		named:     make(map[string]Converter),
+		impls:     make(map[reflect.Type]map[string]reflect.Type),
+		tag:       "schema",
+		factories: make(map[reflect.Type]Factory),
 	}
 	for k, v := range converters {
 		c.conv[k] = v
@@ -28,9 +42,44 @@ func newCache() *cache {
 
 // cache caches meta-data about a struct.
 type cache struct {
-	l    sync.Mutex
-	m    map[reflect.Type]*structInfo
-	conv map[reflect.Type]Converter
+	l         sync.Mutex
+	m         map[cacheKey]*structInfo
+	conv      map[reflect.Type]Converter
+	named     map[string]Converter
+	impls     map[reflect.Type]map[string]reflect.Type
+	factories map[reflect.Type]Factory
+	tag       string
+	norm      func(string) string
+}
+
+// cacheKey identifies meta-data about a struct parsed using a tag name.
+type cacheKey struct {
+	typ reflect.Type
+	tag string
+}
+
+// setNormalizer sets a function to normalize keys and field aliases.
+// It clears the cached meta-data, which was indexed by the old aliases.
+func (c *cache) setNormalizer(fn func(string) string) {
+	c.l.Lock()
+	c.norm = fn
+	c.m = make(map[cacheKey]*structInfo)
+	c.l.Unlock()
+}
+
+// normalize returns the normalized form of a key or field alias.
+func (c *cache) normalize(s string) string {
+	if c.norm == nil {
+		return s
+	}
+	return c.norm(s)
+}
+
+// setTag sets the tag name used to read field aliases and options.
+func (c *cache) setTag(tag string) {
+	c.l.Lock()
+	c.tag = tag
+	c.l.Unlock()
 }
 
 // parsePath parses a path in dotted notation verifying that it is a valid
@@ -51,25 +100,84 @@ func (c *cache) parsePath(p string, t reflect.Type) ([]pathPart, error) {
 		if struc = c.get(t); struc == nil {
 			return nil, invalidPath
 		}
-		if field = struc.get(keys[i]); field == nil {
+		if field = struc.get(c.normalize(keys[i])); field == nil {
+			if struc.unsupported[c.normalize(keys[i])] {
+				return nil, unsupportedPath
+			}
+			if struc.ignored[c.normalize(keys[i])] {
+				return nil, ignoredPath
+			}
 			return nil, invalidPath
 		}
-		// Valid field. Append index.
+		// Valid field. Append index, after the embedded structs for
+		// promoted fields.
+		path = append(path, field.embed...)
 		path = append(path, field.idx)
+		if field.dims > 0 {
+			// Numeric keys after a multi-dimensional slice are indices,
+			// and must be the last keys.
+			var indices []int
+			for ; len(indices) < field.dims && i+1 < len(keys); i++ {
+				if index64, err = strconv.ParseInt(keys[i+1], 10, 0); err != nil ||
+					index64 < 0 {
+					return nil, invalidPath
+				}
+				indices = append(indices, int(index64))
+			}
+			if i+1 < len(keys) {
+				return nil, invalidPath
+			}
+			parts = append(parts, pathPart{
+				path:    path,
+				field:   field,
+				index:   -1,
+				indices: indices,
+			})
+			return parts, nil
+		}
+		if field.isMap {
+			// The remaining keys are the map key.
+			if i+1 >= len(keys) {
+				return nil, invalidPath
+			}
+			parts = append(parts, pathPart{
+				path:   path,
+				field:  field,
+				index:  -1,
+				mapKey: strings.Join(keys[i+1:], "."),
+			})
+			return parts, nil
+		}
+		if field.disc != "" && i+1 < len(keys) {
+			// Interface field: the remaining keys are parsed when the
+			// concrete type is known.
+			parts = append(parts, pathPart{
+				path:  path,
+				field: field,
+				index: -1,
+				rest:  strings.Join(keys[i+1:], "."),
+			})
+			return parts, nil
+		}
 		if field.ss {
 			// Parse a special case: slices of structs.
-			// i+1 must be the slice index, and i+2 must exist.
-			i++
+			// i+1 must exist. If it is the slice index, i+2 must exist.
+			// Otherwise values are spread over the slice elements.
 			if i+1 >= len(keys) {
 				return nil, invalidPath
 			}
-			if index64, err = strconv.ParseInt(keys[i], 10, 0); err != nil {
-				return nil, invalidPath
+			index := -1
+			if index64, err = strconv.ParseInt(keys[i+1], 10, 0); err == nil {
+				if index64 < 0 || i+2 >= len(keys) {
+					return nil, invalidPath
+				}
+				index = int(index64)
+				i++
 			}
 			parts = append(parts, pathPart{
 				path:  path,
 				field: field,
-				index: int(index64),
+				index: index,
 			})
 			path = make([]int, 0)
 
@@ -100,28 +208,79 @@ func (c *cache) parsePath(p string, t reflect.Type) ([]pathPart, error) {
 	return parts, nil
 }
 
+// tokenIndices returns the positions of the non-numeric slice indices in a
+// path in dotted notation: keys following a slice of structs field that are
+// not a field of the struct and are followed by other keys.
+func (c *cache) tokenIndices(p string, t reflect.Type) []int {
+	var idxs []int
+	keys := strings.Split(p, ".")
+	for i := 0; i < len(keys); i++ {
+		field := c.get(t).get(c.normalize(keys[i]))
+		if field == nil || field.disc != "" {
+			break
+		}
+		ft := field.typ
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.ss {
+			if ft = ft.Elem(); ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			// Skip the index, unless values are spread over the elements.
+			if i+2 < len(keys) {
+				if _, err := strconv.ParseInt(keys[i+1], 10, 0); err == nil {
+					i++
+				} else if c.get(ft).get(c.normalize(keys[i+1])) == nil {
+					idxs = append(idxs, i+1)
+					i++
+				}
+			}
+		} else if ft.Kind() != reflect.Struct {
+			break
+		}
+		t = ft
+	}
+	return idxs
+}
+
 // get returns a cached structInfo, creating it if necessary.
 func (c *cache) get(t reflect.Type) *structInfo {
 	c.l.Lock()
-	info := c.m[t]
+	key := cacheKey{typ: t, tag: c.tag}
+	info := c.m[key]
 	c.l.Unlock()
 	if info == nil {
-		info = c.create(t)
+		info = c.create(t, key.tag, nil)
 		c.l.Lock()
-		c.m[t] = info
+		c.m[key] = info
 		c.l.Unlock()
 	}
 	return info
 }
 
-// creat creates a structInfo with meta-data about a struct.
-func (c *cache) create(t reflect.Type) *structInfo {
-	info := &structInfo{fields: make(map[string]*fieldInfo)}
+// creat creates a structInfo with meta-data about a struct, reading field
+// aliases and options from the given tag name.
+//
+// The creating set has the types whose structInfo is being created, to stop
+// promoting fields from embedded structs that embed each other.
+func (c *cache) create(t reflect.Type, tag string,
+	creating map[reflect.Type]bool) *structInfo {
+	info := &structInfo{
+		fields:      make(map[string]*fieldInfo),
+		unsupported: make(map[string]bool),
+		ignored:     make(map[string]bool),
+	}
+	if o, ok := reflect.New(t).Interface().(Optioner); ok {
+		info.opts = o.SchemaOptions()
+	}
+	var embeds []int
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
-		alias := fieldAlias(field)
+		alias, options := fieldAlias(field, tag)
 		if alias == "-" {
 			// Ignore this field.
+			info.ignored[c.normalize(field.Name)] = true
 			continue
 		}
 		// Check if the type is supported and don't cache it if not.
@@ -131,31 +290,170 @@ func (c *cache) create(t reflect.Type) *structInfo {
 		if ft.Kind() == reflect.Ptr {
 			ft = ft.Elem()
 		}
-		if isSlice = ft.Kind() == reflect.Slice; isSlice {
+		// A factory creates the whole field value.
+		factory := c.factories[ft]
+		// Types implementing encoding.TextUnmarshaler decode themselves,
+		// even if they are slices.
+		isText := c.isText(ft)
+		if isSlice = ft.Kind() == reflect.Slice && !isText; isSlice {
 			ft = ft.Elem()
 			if ft.Kind() == reflect.Ptr {
 				ft = ft.Elem()
 			}
+			isText = c.isText(ft)
 		}
-		if isStruct = ft.Kind() == reflect.Struct; !isStruct {
+		// A named converter makes any type supported.
+		convName, _ := options.get("conv")
+		// Slices of slices take an index for each dimension.
+		dims := 0
+		for isSlice && convName == "" && factory == nil &&
+			ft.Kind() == reflect.Slice && c.conv[ft] == nil && !isText {
+			if ft = ft.Elem(); ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			isText = c.isText(ft)
+			dims++
+		}
+		if dims > 0 {
+			// And one more for the outer slice.
+			dims++
+		}
+		// Maps with string keys take the key after the field name.
+		isMap := false
+		if ft.Kind() == reflect.Map && !isSlice && convName == "" &&
+			factory == nil && ft.Key().Kind() == reflect.String {
+			et := ft.Elem()
+			if et.Kind() == reflect.Slice && !c.isText(et) {
+				et = et.Elem()
+			}
+			isMap = c.conv[et] != nil || c.isText(et)
+		}
+		// Structs with a registered converter are converted as a whole.
+		isStruct = ft.Kind() == reflect.Struct && convName == "" &&
+			factory == nil && c.conv[ft] == nil && !isText && dims == 0
+		// Interfaces are supported if implementations were registered.
+		disc := ""
+		if ft.Kind() == reflect.Interface && !isSlice && factory == nil &&
+			c.impls[ft] != nil {
+			if disc, _ = options.get("discriminator"); disc == "" {
+				disc = "type"
+			}
+		}
+		if !isStruct && !isText && !isMap && convName == "" && disc == "" &&
+			factory == nil {
 			if conv := c.conv[ft]; conv == nil {
 				// Type is not supported.
+				info.unsupported[c.normalize(alias)] = true
 				continue
 			}
 		}
-		info.fields[alias] = &fieldInfo{
-			idx: i,
-			typ: field.Type,
-			ss:  isSlice && isStruct,
+		fi := &fieldInfo{
+			idx:      i,
+			typ:      field.Type,
+			ss:       isSlice && isStruct,
+			opts:     info.opts,
+			alias:    alias,
+			convName: convName,
+			disc:     disc,
+			dims:     dims,
+			isMap:    isMap,
+		}
+		_, fi.required = options.get("required")
+		if alt, ok := options.get("alt"); ok && alt != "" {
+			fi.alts = strings.Split(alt, "|")
+		}
+		info.fields[c.normalize(alias)] = fi
+		for _, alt := range fi.alts {
+			if alt = c.normalize(alt); info.fields[alt] == nil {
+				info.fields[alt] = fi
+			}
+		}
+		if field.Anonymous && isStruct && !isSlice && alias == field.Name {
+			embeds = append(embeds, i)
 		}
 	}
+	if creating == nil {
+		creating = make(map[reflect.Type]bool)
+	}
+	creating[t] = true
+	c.promote(info, t, tag, embeds, creating)
+	delete(creating, t)
 	return info
 }
 
+// isText returns true if values of type t are decoded calling UnmarshalText:
+// t implements encoding.TextUnmarshaler and has no registered converter.
+func (c *cache) isText(t reflect.Type) bool {
+	return c.conv[t] == nil && t.Kind() != reflect.Interface &&
+		reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// promote adds to a structInfo the fields of its embedded structs, which
+// can then be set without the embedded struct name as prefix.
+//
+// Like in Go, fields of the struct itself take precedence, then the ones
+// embedded at the shallowest depth. Aliases found more than once at the
+// same depth are ambiguous and are not promoted. Embedded pointers are
+// allocated when one of their fields is set.
+func (c *cache) promote(info *structInfo, t reflect.Type, tag string,
+	embeds []int, creating map[reflect.Type]bool) {
+	type candidate struct {
+		field *fieldInfo
+		count int
+	}
+	candidates := make(map[string]*candidate)
+	for _, i := range embeds {
+		et := t.Field(i).Type
+		if et.Kind() == reflect.Ptr {
+			et = et.Elem()
+		}
+		if creating[et] {
+			// Avoid recursion for structs embedding pointers to themselves
+			// or to each other.
+			continue
+		}
+		embedded := c.create(et, tag, creating)
+		promoted := make(map[*fieldInfo]*fieldInfo)
+		for alias, field := range embedded.fields {
+			if info.fields[alias] != nil || info.unsupported[alias] {
+				continue
+			}
+			fi := promoted[field]
+			if fi == nil {
+				copied := *field
+				copied.embed = append([]int{i}, field.embed...)
+				fi = &copied
+				promoted[field] = fi
+			}
+			cand := candidates[alias]
+			if cand == nil || len(fi.embed) < len(cand.field.embed) {
+				candidates[alias] = &candidate{field: fi, count: 1}
+			} else if len(fi.embed) == len(cand.field.embed) && fi != cand.field {
+				cand.count++
+			}
+		}
+		for alias := range embedded.unsupported {
+			if info.fields[alias] == nil {
+				info.unsupported[alias] = true
+			}
+		}
+	}
+	for alias, cand := range candidates {
+		if cand.count == 1 {
+			info.fields[alias] = cand.field
+		}
+	}
+}
+
 // ----------------------------------------------------------------------------
 
 type structInfo struct {
 	fields map[string]*fieldInfo
+	opts   Options // options defined by the struct type.
+	// Aliases of fields skipped because their type is not supported.
+	unsupported map[string]bool
+	// Names of fields skipped because they are tagged with "-".
+	ignored map[string]bool
 }
 
 func (i *structInfo) get(alias string) *fieldInfo {
@@ -163,33 +461,83 @@ func (i *structInfo) get(alias string) *fieldInfo {
 }
 
 type fieldInfo struct {
-	typ reflect.Type
-	idx int  // field index in the struct.
-	ss  bool // true if this is a slice of structs.
+	typ   reflect.Type
+	idx   int      // field index in the struct.
+	ss    bool     // true if this is a slice of structs.
+	opts  Options  // options defined by the parent struct type.
+	alias string   // field alias.
+	alts  []string // alternative aliases, in order of precedence.
+	// Name of the converter set in the field tag, if any.
+	convName string
+	// Indices of the embedded structs for promoted fields.
+	embed []int
+	// Key for the concrete type name, relative to the field, if this is an
+	// interface field.
+	disc string
+	// Number of indices taken by a multi-dimensional slice: 2 for [][]T.
+	dims int
+	// True if the field must be set. See the "required" tag option.
+	required bool
+	// True if this is a map with string keys.
+	isMap bool
+}
+
+// altIndex returns the precedence of an alias: 0 for the field alias, or
+// the position in the alternative aliases starting from 1.
+func (f *fieldInfo) altIndex(alias string) int {
+	for k, v := range f.alts {
+		if v == alias && alias != f.alias {
+			return k + 1
+		}
+	}
+	return 0
 }
 
 type pathPart struct {
 	field *fieldInfo
 	path  []int // path to the field: walks structs using field indices.
-	index int   // struct index in slices of structs.
+	index int   // struct index in slices of structs; -1 to spread values.
+	// For interface fields, the path for the concrete type fields, and the
+	// concrete type name read from the discriminator key.
+	rest     string
+	discName string
+	// Indices for the extra dimensions of a multi-dimensional slice.
+	indices []int
+	// The key for map fields.
+	mapKey string
 }
 
 // ----------------------------------------------------------------------------
 
-// fieldAlias parses a field tag to get a field alias.
-func fieldAlias(field reflect.StructField) string {
+// fieldAlias parses a field tag to get a field alias and tag options.
+func fieldAlias(field reflect.StructField, tagName string) (string, tagOptions) {
 	var alias string
-	if tag := field.Tag.Get("schema"); tag != "" {
-		// For now tags only support the name but let's folow the
-		// comma convention from encoding/json and others.
-		if idx := strings.Index(tag, ","); idx == -1 {
-			alias = tag
-		} else {
-			alias = tag[:idx]
-		}
+	var options tagOptions
+	if tag := field.Tag.Get(tagName); tag != "" {
+		// Follow the comma convention from encoding/json and others.
+		parts := strings.Split(tag, ",")
+		alias, options = parts[0], tagOptions(parts[1:])
 	}
 	if alias == "" {
 		alias = field.Name
 	}
-	return alias
+	return alias, options
+}
+
+// tagOptions are the comma-separated options following the alias in a
+// field tag, as in "name,option,key=value".
+type tagOptions []string
+
+// get returns the value for an option and true if it is set. For options
+// without a value it returns an empty string.
+func (o tagOptions) get(name string) (string, bool) {
+	for _, v := range o {
+		if v == name {
+			return "", true
+		}
+		if strings.HasPrefix(v, name+"=") {
+			return v[len(name)+1:], true
+		}
+	}
+	return "", false
 }
@@ -11,6 +11,10 @@ import (
 
 type Converter func(string) reflect.Value
 
+// Factory creates a field value from all values for its key.
+// See Decoder.RegisterFactory().
+type Factory func(values []string) (interface{}, error)
+
 var (
 	invalidValue = reflect.Value{}
 	boolType     = reflect.TypeOf(false)
@@ -141,3 +145,23 @@ func convertUint64(value string) reflect.Value {
 	}
 	return invalidValue
 }
+
+// intConverter returns a converter for an int variant using the given base.
+func intConverter(t reflect.Type, base int) Converter {
+	return func(value string) reflect.Value {
+		if v, err := strconv.ParseInt(value, base, t.Bits()); err == nil {
+			return reflect.ValueOf(v).Convert(t)
+		}
+		return invalidValue
+	}
+}
+
+// uintConverter returns a converter for an uint variant using the given base.
+func uintConverter(t reflect.Type, base int) Converter {
+	return func(value string) reflect.Value {
+		if v, err := strconv.ParseUint(value, base, t.Bits()); err == nil {
+			return reflect.ValueOf(v).Convert(t)
+		}
+		return invalidValue
+	}
+}
@@ -5,11 +5,31 @@
 package schema
 
 import (
+	"encoding"
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// Default is the Decoder used by the package-level Decode and
+// RegisterConverter functions.
+var Default = NewDecoder()
+
+// Decode decodes a map[string][]string to a struct using the Default decoder.
+// See Decoder.Decode().
+func Decode(dst interface{}, src map[string][]string) error {
+	return Default.Decode(dst, src)
+}
+
+// RegisterConverter registers a converter function for a custom type in the
+// Default decoder. See Decoder.RegisterConverter().
+func RegisterConverter(value interface{}, converterFunc Converter) {
+	Default.RegisterConverter(value, converterFunc)
+}
+
 // NewDecoder returns a new Decoder.
 func NewDecoder() *Decoder {
 	return &Decoder{cache: newCache()}
@@ -17,14 +37,247 @@ func NewDecoder() *Decoder {
 
 // Decoder decodes values from a map[string][]string to a struct.
 type Decoder struct {
-	cache *cache
+	cache        *cache
+	presenceMark string
+	maxKeys      int
+	maxIndex     int
+	options      Options
+	decimalSep   string
+	groupSep     string
+	failFast     bool
+	indexTokens  bool
+	pathParser   func(string) []string
+	skipUnknown  bool
+}
+
+// Options are flags that control decoding behavior.
+type Options int
+
+const (
+	// TrimSpace removes leading and trailing white space from values.
+	TrimSpace Options = 1 << iota
+	// ZeroEmpty sets fields to their zero value for empty values, instead
+	// of ignoring them.
+	ZeroEmpty
+)
+
+// Optioner is implemented by struct types that define their own decoding
+// options.
+//
+// The options returned by SchemaOptions() are combined with the decoder
+// options when decoding the fields of the struct. The method is called
+// on a zero value and must not depend on the struct contents.
+type Optioner interface {
+	SchemaOptions() Options
+}
+
+// SetOptions sets the decoding options, e.g. TrimSpace|ZeroEmpty.
+//
+// Struct types that implement the Optioner interface can enable additional
+// options for their fields.
+func (d *Decoder) SetOptions(opts Options) {
+	d.options = opts
+}
+
+// SetAliasTag sets the struct tag name used to read field aliases and
+// options. The default is "schema".
+func (d *Decoder) SetAliasTag(tag string) {
+	d.cache.setTag(tag)
+}
+
+// SetKeyNormalizer sets a function to normalize source keys and field
+// aliases before they are compared. For example, a function that removes
+// underscores and converts to lower case maps the key "user_name" to a
+// field "UserName".
+//
+// The function is applied to each part of a dotted path. It must be
+// deterministic and must not map different aliases in a struct to the same
+// value, otherwise the field that is filled is undefined.
+func (d *Decoder) SetKeyNormalizer(fn func(string) string) {
+	d.cache.setNormalizer(fn)
+}
+
+// RegisterFactory registers a factory function that creates values for
+// fields of the same type as value, or pointers to it.
+//
+// The factory receives all values for the field key and returns the field
+// value, which must be of the registered type. This allows to decode types
+// that can't be set field by field, e.g. to keep invariants:
+//
+//	decoder.RegisterFactory(Money{}, func(values []string) (interface{}, error) {
+//		return ParseMoney(values[0])
+//	})
+//
+// Errors returned by the factory are reported for the field key. Factories
+// take precedence over converters, and like them must be registered before
+// the decoder is used.
+func (d *Decoder) RegisterFactory(value interface{}, factory Factory) {
+	d.cache.factories[reflect.TypeOf(value)] = factory
+}
+
+// RegisterNamedConverter registers a converter function that fields select
+// by name using the "conv" tag option, instead of the converter for their
+// type. For example, the converter registered as "base64" is used for the
+// field:
+//
+//	Data []byte `schema:"data,conv=base64"`
+//
+// A named converter converts the whole field value, so for slices it must
+// return a value of the slice type, and only the first value is used.
+func (d *Decoder) RegisterNamedConverter(name string, converterFunc Converter) {
+	d.cache.named[name] = converterFunc
+}
+
+// PresenceMarker sets a key used to signal the presence of a nested struct
+// without setting any of its fields.
+//
+// Pointers to nested structs are only allocated when a key for one of their
+// fields is present. With a marker set to "_present", a key like
+// "Phone._present" also allocates the Phone field, leaving it empty. This
+// allows to distinguish "not provided" from "provided empty".
+func (d *Decoder) PresenceMarker(key string) {
+	d.presenceMark = key
 }
 
 // RegisterConverter registers a converter function for a custom type.
+//
+// Converters must be registered before the decoder is used: a Decoder is
+// safe for concurrent use, but not while new converters are registered.
 func (d *Decoder) RegisterConverter(value interface{}, converterFunc Converter) {
 	d.cache.conv[reflect.TypeOf(value)] = converterFunc
 }
 
+// RegisterImpl registers a concrete type for an interface, selected by name
+// when decoding into fields of the interface type.
+//
+// The name is read from a discriminator key relative to the field, "type" by
+// default, and other keys for the field fill the concrete type:
+//
+//	type Payment struct {
+//		Method Method `schema:"method,discriminator=kind"`
+//	}
+//
+//	decoder.RegisterImpl(methodType, "card", reflect.TypeOf(&Card{}))
+//	decoder.RegisterImpl(methodType, "transfer", reflect.TypeOf(&Transfer{}))
+//
+// With these, the keys "method.kind=card" and "method.Number=1234" set
+// Method to a *Card with the given Number. The concrete type must be a
+// struct or pointer to struct implementing the interface, otherwise
+// RegisterImpl panics.
+//
+// Like converters, implementations must be registered before the decoder
+// is used.
+func (d *Decoder) RegisterImpl(iface reflect.Type, name string, concrete reflect.Type) {
+	st := concrete
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if iface.Kind() != reflect.Interface || st.Kind() != reflect.Struct ||
+		!concrete.Implements(iface) {
+		panic(fmt.Sprintf("schema: %v is not a struct implementing %v",
+			concrete, iface))
+	}
+	if d.cache.impls[iface] == nil {
+		d.cache.impls[iface] = make(map[string]reflect.Type)
+	}
+	d.cache.impls[iface][name] = concrete
+}
+
+// MaxKeys sets the maximum number of keys accepted from a source.
+//
+// If the source has more keys, decoding fails before any of them is
+// processed. This bounds the work done for untrusted input. The default,
+// 0, means no limit.
+func (d *Decoder) MaxKeys(n int) {
+	d.maxKeys = n
+}
+
+// MaxIndex sets the maximum slice index accepted in a key, as in
+// "Phones.3.Number" or "Grid.2.5".
+//
+// Keys with a greater index are reported as errors and not decoded, so
+// untrusted input can't allocate huge slices. The default is 0, which
+// means no limit.
+func (d *Decoder) MaxIndex(n int) {
+	d.maxIndex = n
+}
+
+// IntBase sets the base used to convert int and uint variants.
+//
+// The default base is 10. If base is 0, it is implied by the value prefix:
+// "0x" for hexadecimal, "0o" or "0" for octal, "0b" for binary and decimal
+// otherwise. See strconv.ParseInt().
+//
+// This replaces the converters registered for int and uint variants.
+func (d *Decoder) IntBase(base int) {
+	for _, t := range []reflect.Type{intType, int8Type, int16Type, int32Type,
+		int64Type} {
+		d.cache.conv[t] = intConverter(t, base)
+	}
+	for _, t := range []reflect.Type{uintType, uint8Type, uint16Type,
+		uint32Type, uint64Type} {
+		d.cache.conv[t] = uintConverter(t, base)
+	}
+}
+
+// FailFast defines if decoding stops at the first error.
+//
+// By default all keys are decoded and errors are returned together in a
+// MultiError. When true, decoding stops at the first key that fails and its
+// error is returned alone, leaving the remaining keys unprocessed. Keys from
+// a map are not processed in a defined order, so "first" is not the first
+// key in the form and fields for other valid keys may or may not be set.
+func (d *Decoder) FailFast(value bool) {
+	d.failFast = value
+}
+
+// IgnoreUnknownKeys defines if keys that don't map to a struct field are
+// ignored.
+//
+// By default an unknown key is reported as an error in the returned
+// MultiError, which helps to catch typos. When true, unknown keys are
+// skipped. Keys for fields tagged with "-" are always skipped, and keys for
+// fields of unsupported types are always errors.
+func (d *Decoder) IgnoreUnknownKeys(value bool) {
+	d.skipUnknown = value
+}
+
+// SetPathParser sets a function to split source keys into path parts, for
+// keys that don't use the dotted notation. For example, BracketPath splits
+// "phones[0][number]" into ["phones", "0", "number"], the same parts as
+// "phones.0.number". Parts must not contain dots.
+//
+// The default, or a nil function, splits keys on dots. Errors and reports
+// use the original keys.
+func (d *Decoder) SetPathParser(fn func(string) []string) {
+	d.pathParser = fn
+}
+
+// IndexTokens defines if slices of structs accept non-numeric indices.
+//
+// When true, a key in place of the slice index that is not a field of the
+// struct, as in "items.abc.Name", is a token for an element. Tokens for the
+// same slice are sorted and assigned sequential positions, so the keys
+// "items.xyz.Name" and "items.abc.Name" fill items[1] and items[0]. The
+// positions don't depend on the order of the source keys.
+//
+// Tokens and numeric indices should not be mixed for the same slice. Errors
+// and reports still use the original keys.
+func (d *Decoder) IndexTokens(value bool) {
+	d.indexTokens = value
+}
+
+// NumberFormat sets the separators used in values for int, uint and float
+// fields, for forms submitted in locale-specific formats.
+//
+// Before conversion, grouping separators are removed and the decimal
+// separator is replaced by a dot. For example, with a decimal separator ","
+// and a grouping separator ".", "1.000,50" is decoded as 1000.5. The
+// default is a dot decimal separator and no grouping.
+func (d *Decoder) NumberFormat(decimal, grouping string) {
+	d.decimalSep, d.groupSep = decimal, grouping
+}
+
 // Decode decodes a map[string][]string to a struct.
 //
 // The first parameter must be a pointer to a struct.
@@ -34,31 +287,361 @@ func (d *Decoder) RegisterConverter(value interface{}, converterFunc Converter)
 //
 // See the package documentation for a full explanation of the mechanics.
 func (d *Decoder) Decode(dst interface{}, src map[string][]string) error {
+	_, err := d.DecodeReporting(dst, src)
+	return err
+}
+
+// DecodeWithKeyMap decodes a map[string][]string to a struct like Decode(),
+// renaming source keys before they are resolved to struct fields.
+//
+// The keyMap maps source keys to field paths, e.g. "user_name" to "Name".
+// Keys not in keyMap are used as they are. If a field path is present both
+// in the source map and as the target of a renamed key, the value from the
+// renamed key takes precedence.
+func (d *Decoder) DecodeWithKeyMap(dst interface{}, src map[string][]string,
+	keyMap map[string]string) error {
+	mapped := make(map[string][]string, len(src))
+	for k, v := range src {
+		if _, ok := keyMap[k]; !ok {
+			mapped[k] = v
+		}
+	}
+	for k, v := range src {
+		if path, ok := keyMap[k]; ok {
+			mapped[path] = v
+		}
+	}
+	return d.Decode(dst, mapped)
+}
+
+// DecodeReporting decodes a map[string][]string to a struct like Decode(),
+// and also returns the sorted paths of the fields that were set.
+//
+// Keys with empty values or that failed to decode are not reported, so the
+// result lists exactly the fields changed in the destination struct. This
+// is useful, for example, to apply partial updates.
+func (d *Decoder) DecodeReporting(dst interface{}, src map[string][]string) ([]string, error) {
+	report, err := d.decodeSource(dst, MapSource(src))
+	return report.setPaths(), err
+}
+
+// DecodeVerbose decodes a map[string][]string to a struct like Decode(),
+// and also returns a Report with the outcome for each source key.
+//
+// This is a diagnostic aid to find out why a field was not populated.
+func (d *Decoder) DecodeVerbose(dst interface{}, src map[string][]string) (Report, error) {
+	return d.decodeSource(dst, MapSource(src))
+}
+
+// DecodeSource decodes values from a Source to a struct.
+// See Decoder.Decode().
+func (d *Decoder) DecodeSource(dst interface{}, src Source) error {
+	_, err := d.decodeSource(dst, src)
+	return err
+}
+
+// decodeSource decodes values from a Source to a struct and returns the
+// outcome for each source key.
+func (d *Decoder) decodeSource(dst interface{}, src Source) (Report, error) {
+	if _, ok := src.(*parsedSource); !ok && d.pathParser != nil {
+		return d.decodeParsed(dst, newParsedSource(src, d.pathParser))
+	}
+	keys := src.Keys()
+	if d.maxKeys > 0 && len(keys) > d.maxKeys {
+		return nil, fmt.Errorf("schema: too many keys, got %d, maximum is %d",
+			len(keys), d.maxKeys)
+	}
 	v := reflect.ValueOf(dst)
 	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
-		return errors.New("schema: interface must be a pointer to struct")
+		return nil, errors.New("schema: interface must be a pointer to struct")
 	}
 	v = v.Elem()
 	t := v.Type()
+	report := make(Report, len(keys))
 	errors := MultiError{}
-	for path, values := range src {
-		if parts, err := d.cache.parsePath(path, t); err == nil {
-			if err = d.decode(v, path, parts, values); err != nil {
+	// Index paths of the fields set with a non-empty value.
+	satisfied := make(map[string]bool)
+	var indexPaths map[string]string
+	if d.indexTokens {
+		indexPaths = d.indexPaths(keys, t)
+	}
+	for _, path := range keys {
+		if d.failFast && len(errors) > 0 {
+			break
+		}
+		values, ok := src.Values(path)
+		if !ok || len(values) == 0 {
+			report[path] = KeyReport{Outcome: KeyEmpty}
+			continue
+		}
+		// The path with numeric indices in place of tokens.
+		parsed := path
+		if p, ok := indexPaths[path]; ok {
+			parsed = p
+		}
+		if d.isPresenceMark(path) {
+			if err := d.decodePresence(v, parsed); err != nil {
 				errors[path] = err
+				report[path] = KeyReport{Outcome: KeyError, Err: err}
+			} else {
+				report[path] = KeyReport{Outcome: KeyMarker}
 			}
-		} else {
+			continue
+		}
+		parts, err := d.cache.parsePath(parsed, t)
+		if err == ignoredPath || (err == invalidPath && d.skipUnknown) {
+			report[path] = KeyReport{Outcome: KeyNoField}
+			continue
+		}
+		if err != nil {
 			errors[path] = fmt.Errorf("schema: invalid path %q", path)
+			outcome := KeyNoField
+			if err == unsupportedPath {
+				outcome = KeyUnsupported
+			}
+			report[path] = KeyReport{Outcome: outcome, Err: errors[path]}
+			continue
+		}
+		if !d.validIndices(parts) {
+			errors[path] = fmt.Errorf("schema: index out of range in %q, maximum is %d",
+				path, d.maxIndex)
+			report[path] = KeyReport{Outcome: KeyError, Err: errors[path]}
+			continue
+		}
+		last := &parts[len(parts)-1]
+		if d.isShadowed(src, path, last.field) {
+			report[path] = KeyReport{Outcome: KeyShadowed}
+			continue
+		}
+		if last.rest != "" {
+			prefix := path[:len(path)-len(last.rest)]
+			if disc, ok := src.Values(prefix + last.field.disc); ok && len(disc) > 0 {
+				last.discName = disc[0]
+			}
+		}
+		var set bool
+		if set, err = d.decode(v, path, parts, values); err == nil && set &&
+			len(parts) == 1 && last.rest == "" && hasValue(values) {
+			// Nested struct fields also satisfy their parents.
+			for k := range last.path {
+				satisfied[fmt.Sprint(last.path[:k+1])] = true
+			}
+		}
+		if err != nil {
+			errors[path] = err
+			outcome := KeyError
+			if _, ok := err.(ConversionError); ok {
+				outcome = KeyConversionError
+			}
+			report[path] = KeyReport{Outcome: outcome, Err: err}
+		} else if set {
+			report[path] = KeyReport{Outcome: KeySet}
+		} else {
+			report[path] = KeyReport{Outcome: KeyEmpty}
+		}
+	}
+	if !d.failFast || len(errors) == 0 {
+		d.checkRequired(v, "", nil, satisfied, errors, report)
+	}
+	if len(errors) == 0 {
+		if validator, ok := dst.(Validator); ok {
+			return report, validator.Validate(report.setPaths())
 		}
 	}
 	if len(errors) > 0 {
-		return errors
+		if d.failFast {
+			for _, err := range errors {
+				return report, err
+			}
+		}
+		return report, errors
+	}
+	return report, nil
+}
+
+// checkRequired adds errors for the required fields of a struct that were
+// not set with a non-empty value. Nested structs are checked if they were
+// allocated; fields in slices of structs are not checked.
+func (d *Decoder) checkRequired(v reflect.Value, prefix string, path []int,
+	satisfied map[string]bool, errors MultiError, report Report) {
+	info := d.cache.get(v.Type())
+	for alias, field := range info.fields {
+		if alias != d.cache.normalize(field.alias) {
+			// Alternative alias.
+			continue
+		}
+		key := prefix + field.alias
+		idx := append(append(path[:len(path):len(path)], field.embed...),
+			field.idx)
+		if field.required && !satisfied[fmt.Sprint(idx)] {
+			errors[key] = fmt.Errorf("schema: missing required field %q", key)
+			report[key] = KeyReport{Outcome: KeyMissing, Err: errors[key]}
+			continue
+		}
+		// Embedded structs are checked through their promoted fields.
+		if field.embed != nil || v.Type().Field(field.idx).Anonymous {
+			continue
+		}
+		fv := v.Field(field.idx)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct && field.disc == "" {
+			d.checkRequired(fv, key+".", idx, satisfied, errors, report)
+		}
+	}
+}
+
+// validIndices returns true if the slice indices in the path parts are not
+// greater than the maximum index.
+func (d *Decoder) validIndices(parts []pathPart) bool {
+	if d.maxIndex <= 0 {
+		return true
+	}
+	for _, part := range parts {
+		if part.index > d.maxIndex {
+			return false
+		}
+		for _, index := range part.indices {
+			if index > d.maxIndex {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hasValue returns true if any of the values is not empty.
+func hasValue(values []string) bool {
+	for _, v := range values {
+		if v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeParsed decodes values from a parsedSource, using the original source
+// keys in the returned report and errors.
+func (d *Decoder) decodeParsed(dst interface{}, src *parsedSource) (Report, error) {
+	report, err := d.decodeSource(dst, src)
+	if report == nil {
+		return nil, err
+	}
+	origReport := make(Report, len(report))
+	for k, v := range report {
+		origReport[src.original(k)] = v
+	}
+	if errs, ok := err.(MultiError); ok {
+		origErrs := make(MultiError, len(errs))
+		for k, v := range errs {
+			origErrs[src.original(k)] = v
+		}
+		err = origErrs
+	}
+	return origReport, err
+}
+
+// indexPaths maps keys with non-numeric slice indices to paths with the
+// positions of the tokens. See Decoder.IndexTokens().
+func (d *Decoder) indexPaths(keys []string, t reflect.Type) map[string]string {
+	// A token is identified by the key parts before it and its value.
+	type token struct {
+		prefix, value string
+	}
+	tokens := make(map[string][]string)
+	seen := make(map[token]bool)
+	found := make(map[string][]int)
+	for _, key := range keys {
+		idxs := d.cache.tokenIndices(key, t)
+		if len(idxs) == 0 {
+			continue
+		}
+		found[key] = idxs
+		parts := strings.Split(key, ".")
+		for _, i := range idxs {
+			tk := token{strings.Join(parts[:i], "."), parts[i]}
+			if !seen[tk] {
+				seen[tk] = true
+				tokens[tk.prefix] = append(tokens[tk.prefix], tk.value)
+			}
+		}
+	}
+	positions := make(map[token]int)
+	for prefix, values := range tokens {
+		sort.Strings(values)
+		for k, v := range values {
+			positions[token{prefix, v}] = k
+		}
+	}
+	paths := make(map[string]string, len(found))
+	for key, idxs := range found {
+		parts := strings.Split(key, ".")
+		replaced := append([]string(nil), parts...)
+		for _, i := range idxs {
+			pos := positions[token{strings.Join(parts[:i], "."), parts[i]}]
+			replaced[i] = strconv.Itoa(pos)
+		}
+		paths[key] = strings.Join(replaced, ".")
 	}
-	return nil
+	return paths
+}
+
+// isShadowed returns true if the path uses an alternative alias for a field
+// and a key with higher precedence for the same field has a value.
+func (d *Decoder) isShadowed(src Source, path string, field *fieldInfo) bool {
+	if len(field.alts) == 0 {
+		return false
+	}
+	prefix, alias := "", path
+	if idx := strings.LastIndex(path, "."); idx != -1 {
+		prefix, alias = path[:idx+1], path[idx+1:]
+	}
+	n := field.altIndex(alias)
+	for k := 0; k < n; k++ {
+		other := field.alias
+		if k > 0 {
+			other = field.alts[k-1]
+		}
+		if values, ok := src.Values(prefix + other); ok && len(values) > 0 &&
+			values[0] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isPresenceMark returns true if the path ends with the presence marker.
+func (d *Decoder) isPresenceMark(path string) bool {
+	return d.presenceMark != "" && strings.HasSuffix(path, "."+d.presenceMark)
+}
+
+// decodePresence allocates the nested struct signaled by a presence marker.
+func (d *Decoder) decodePresence(v reflect.Value, path string) error {
+	path = path[:len(path)-len(d.presenceMark)-1]
+	parts, err := d.cache.parsePath(path, v.Type())
+	if err == nil {
+		t := parts[len(parts)-1].field.typ
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			_, err = d.decode(v, path, parts, nil)
+			return err
+		}
+	}
+	return fmt.Errorf("schema: invalid path %q", path)
 }
 
 // decode fills a struct field using a parsed path.
+// It returns true if the field was set.
+//
+// If values is nil, the field is only allocated.
 func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart,
-	values []string) error {
+	values []string) (bool, error) {
 	// Get the field walking the struct fields by index.
 	for _, idx := range parts[0].path {
 		if v.Type().Kind() == reflect.Ptr {
@@ -80,6 +663,51 @@ func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart,
 		v = v.Elem()
 	}
 
+	// Presence marker: nothing else to set.
+	if values == nil && len(parts) == 1 {
+		return false, nil
+	}
+
+	// Multi-dimensional slice: walk the indices, resizing each dimension.
+	for _, idx := range parts[0].indices {
+		if v.Len() < idx+1 {
+			value := reflect.MakeSlice(t, idx+1, idx+1)
+			reflect.Copy(value, v)
+			v.Set(value)
+		}
+		v = v.Index(idx)
+		if t = v.Type(); t.Kind() == reflect.Ptr {
+			t = t.Elem()
+			if v.IsNil() {
+				v.Set(reflect.New(t))
+			}
+			v = v.Elem()
+		}
+	}
+
+	// Interface field: fill the concrete type.
+	if parts[0].rest != "" {
+		return d.decodeImpl(v, path, parts[0], values)
+	}
+
+	// Slice of structs without index: each value goes to an element.
+	if len(parts) > 1 && parts[0].index < 0 {
+		if v.Len() < len(values) {
+			value := reflect.MakeSlice(t, len(values), len(values))
+			reflect.Copy(value, v)
+			v.Set(value)
+		}
+		set := false
+		for k := range values {
+			ok, err := d.decode(v.Index(k), path, parts[1:], values[k:k+1])
+			if err != nil {
+				return set, err
+			}
+			set = set || ok
+		}
+		return set, nil
+	}
+
 	// Slice of structs. Let's go recursive.
 	if len(parts) > 1 {
 		idx := parts[0].index
@@ -94,52 +722,217 @@ func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart,
 		return d.decode(v.Index(idx), path, parts[1:], values)
 	}
 
+	// Map field: set the value for the map key.
+	if parts[0].field.isMap {
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(t))
+		}
+		key := reflect.ValueOf(parts[0].mapKey).Convert(t.Key())
+		elem := reflect.New(t.Elem()).Elem()
+		set, err := d.decodeValue(elem, t.Elem(), path, parts[0].field, values)
+		if set {
+			v.SetMapIndex(key, elem)
+		}
+		return set, err
+	}
+
 	// Simple case.
-	if t.Kind() == reflect.Slice {
-		items := make([]reflect.Value, len(values))
+	return d.decodeValue(v, t, path, parts[0].field, values)
+}
+
+// decodeValue sets a value of type t, which is not a struct, from the
+// values for a key.
+func (d *Decoder) decodeValue(v reflect.Value, t reflect.Type, path string,
+	field *fieldInfo, values []string) (bool, error) {
+	opts := d.options | field.opts
+	if opts&TrimSpace != 0 {
+		trimmed := make([]string, len(values))
+		for k, v := range values {
+			trimmed[k] = strings.TrimSpace(v)
+		}
+		values = trimmed
+	}
+	if factory := d.cache.factories[t]; factory != nil {
+		if values[0] == "" && len(values) == 1 {
+			if opts&ZeroEmpty != 0 {
+				v.Set(reflect.Zero(t))
+				return true, nil
+			}
+			// We are just ignoring empty values for now.
+			return false, nil
+		}
+		value, err := factory(values)
+		if err != nil {
+			return false, err
+		}
+		rv := reflect.ValueOf(value)
+		if !rv.IsValid() || rv.Type() != t {
+			return false, fmt.Errorf("schema: factory for %v returned %T",
+				t, value)
+		}
+		v.Set(rv)
+	} else if t.Kind() == reflect.Slice && field.convName == "" &&
+		!d.cache.isText(t) {
+		items := make([]reflect.Value, 0, len(values))
 		elemT := t.Elem()
 		isPtrElem := elemT.Kind() == reflect.Ptr
 		if isPtrElem {
 			elemT = elemT.Elem()
 		}
-		conv := d.cache.conv[elemT]
-		if conv == nil {
-			return fmt.Errorf("schema: converter not found for %v", elemT)
+		isText := d.cache.isText(elemT)
+		var conv Converter
+		if !isText {
+			var err error
+			if conv, err = d.converter(field, elemT); err != nil {
+				return false, err
+			}
 		}
 		for key, value := range values {
-			if value == "" {
+			if value == "" && opts&ZeroEmpty == 0 {
 				// We are just ignoring empty values for now.
 				continue
-			} else if item := conv(value); item.IsValid() {
+			}
+			item := reflect.Zero(elemT)
+			if value != "" && isText {
+				var err error
+				if item, err = unmarshalText(elemT, value); err != nil {
+					return false, ConversionError{path, key, err}
+				}
+			} else if value != "" {
+				item = conv(value)
+			}
+			if item.IsValid() {
 				if isPtrElem {
 					ptr := reflect.New(elemT)
 					ptr.Elem().Set(item)
 					item = ptr
 				}
-				items[key] = item
+				items = append(items, item)
 			} else {
 				// If a single value is invalid should we give up
 				// or set a zero value?
-				return ConversionError{path, key}
+				return false, ConversionError{path, key, nil}
 			}
 		}
 		value := reflect.Append(reflect.MakeSlice(t, 0, 0), items...)
 		v.Set(value)
 	} else {
 		if values[0] == "" {
+			if opts&ZeroEmpty != 0 {
+				v.Set(reflect.Zero(t))
+				return true, nil
+			}
 			// We are just ignoring empty values for now.
-			return nil
-		} else if conv := d.cache.conv[t]; conv != nil {
+			return false, nil
+		} else if field.convName == "" && d.cache.isText(t) {
+			value, err := unmarshalText(t, values[0])
+			if err != nil {
+				return false, ConversionError{path, -1, err}
+			}
+			v.Set(value)
+		} else if conv, err := d.converter(field, t); err == nil {
 			if value := conv(values[0]); value.IsValid() {
 				v.Set(value)
 			} else {
-				return ConversionError{path, -1}
+				return false, ConversionError{path, -1, nil}
 			}
 		} else {
-			return fmt.Errorf("schema: converter not found for %v", t)
+			return false, err
 		}
 	}
-	return nil
+	return true, nil
+}
+
+// decodeImpl fills an interface field with the concrete type selected by the
+// discriminator. An existing value of the same type is kept and updated.
+func (d *Decoder) decodeImpl(v reflect.Value, path string, part pathPart,
+	values []string) (bool, error) {
+	concrete := d.cache.impls[v.Type()][part.discName]
+	if concrete == nil {
+		return false, fmt.Errorf("schema: unknown type %q for %q",
+			part.discName, path)
+	}
+	var target reflect.Value
+	if cur := v.Elem(); cur.IsValid() && cur.Type() == concrete {
+		target = cur
+	} else if concrete.Kind() == reflect.Ptr {
+		target = reflect.New(concrete.Elem())
+	} else {
+		target = reflect.Zero(concrete)
+	}
+	// Work on an addressable struct and set the interface when done.
+	var st reflect.Value
+	if concrete.Kind() == reflect.Ptr {
+		st = target.Elem()
+	} else {
+		st = reflect.New(concrete).Elem()
+		st.Set(target)
+		target = st
+	}
+	var set bool
+	if part.rest != part.field.disc {
+		// Other than the discriminator, keys are for the concrete type.
+		parts, err := d.cache.parsePath(part.rest, st.Type())
+		if err != nil {
+			return false, fmt.Errorf("schema: invalid path %q", path)
+		}
+		if set, err = d.decode(st, path, parts, values); err != nil {
+			return false, err
+		}
+	}
+	v.Set(target)
+	return set, nil
+}
+
+// converter returns the converter for a field: the one named in the field
+// tag, if any, or the one registered for its type.
+func (d *Decoder) converter(field *fieldInfo, t reflect.Type) (Converter, error) {
+	if field.convName != "" {
+		if conv := d.cache.named[field.convName]; conv != nil {
+			return conv, nil
+		}
+		return nil, fmt.Errorf("schema: converter %q not found", field.convName)
+	}
+	conv := d.cache.conv[t]
+	if conv == nil {
+		return nil, fmt.Errorf("schema: converter not found for %v", t)
+	}
+	if isNumber(t) && (d.groupSep != "" || (d.decimalSep != "" && d.decimalSep != ".")) {
+		return func(value string) reflect.Value {
+			return conv(d.normalizeNumber(value))
+		}, nil
+	}
+	return conv, nil
+}
+
+// unmarshalText creates a value of type t calling its UnmarshalText method.
+func unmarshalText(t reflect.Type, value string) (reflect.Value, error) {
+	ptr := reflect.New(t)
+	err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	return ptr.Elem(), err
+}
+
+// normalizeNumber converts a number in the format set with NumberFormat()
+// to the format expected by the strconv package.
+func (d *Decoder) normalizeNumber(value string) string {
+	if d.groupSep != "" {
+		value = strings.Replace(value, d.groupSep, "", -1)
+	}
+	if d.decimalSep != "" && d.decimalSep != "." {
+		value = strings.Replace(value, d.decimalSep, ".", -1)
+	}
+	return value
+}
+
+// isNumber returns true if t is an int, uint or float variant.
+func isNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
 }
 
 // Errors ---------------------------------------------------------------------
@@ -148,14 +941,21 @@ func (d *Decoder) decode(v reflect.Value, path string, parts []pathPart,
 type ConversionError struct {
 	Key   string // key from the source map.
 	Index int    // index for multi-value fields; -1 for single-value fields.
+	Err   error  // error from UnmarshalText, if any.
 }
 
 func (e ConversionError) Error() string {
+	var s string
 	if e.Index < 0 {
-		return fmt.Sprintf("schema: error converting value for %q", e.Key)
+		s = fmt.Sprintf("schema: error converting value for %q", e.Key)
+	} else {
+		s = fmt.Sprintf("schema: error converting value for index %d of %q",
+			e.Index, e.Key)
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
 	}
-	return fmt.Sprintf("schema: error converting value for index %d of %q",
-		e.Index, e.Key)
+	return s
 }
 
 // MultiError stores multiple decoding errors.
@@ -5,8 +5,15 @@
 package schema
 
 import (
-	//"reflect"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // All cases we want to cover, in a nutshell.
@@ -516,3 +523,1595 @@ func TestConversionError(t *testing.T) {
 		t.Errorf("Expected 3 errors, got %v", m)
 	}
 }
+
+func TestConversionErrorMixed(t *testing.T) {
+	data := map[string][]string{
+		"F01": {"42"},
+		"F02": {"bar"},
+		"F03": {"true"},
+	}
+	s := &S4{}
+	err := NewDecoder().Decode(s, data)
+	m, ok := err.(MultiError)
+	if !ok || len(m) != 1 {
+		t.Fatalf("Expected 1 error, got %v", err)
+	}
+	if e, ok := m["F02"].(ConversionError); !ok || e.Key != "F02" || e.Index != -1 {
+		t.Errorf("Expected a ConversionError for F02, got %v", m["F02"])
+	}
+	// Valid fields are still set.
+	if s.F01 != 42 || s.F02 != 0 || !s.F03 {
+		t.Errorf("Unexpected decoded struct %+v", s)
+	}
+
+	// Errors for slice values report the index.
+	err = NewDecoder().Decode(&S1{}, map[string][]string{"f3": {"1", "x"}})
+	m, ok = err.(MultiError)
+	if !ok || len(m) != 1 {
+		t.Fatalf("Expected 1 error, got %v", err)
+	}
+	if e, ok := m["f3"].(ConversionError); !ok || e.Index != 1 {
+		t.Errorf("Expected a ConversionError for index 1 of f3, got %v", m["f3"])
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+type rudeBool bool
+
+type S5 struct {
+	F01 string
+	F02 rudeBool
+}
+
+func TestDefaultDecoder(t *testing.T) {
+	RegisterConverter(rudeBool(false), func(s string) reflect.Value {
+		switch s {
+		case "yup":
+			return reflect.ValueOf(rudeBool(true))
+		case "nope":
+			return reflect.ValueOf(rudeBool(false))
+		}
+		return reflect.Value{}
+	})
+	data := map[string][]string{
+		"F01": {"foo"},
+		"F02": {"yup"},
+	}
+	s := &S5{}
+	if err := Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.F01 != "foo" {
+		t.Errorf("F01: expected %q, got %q", "foo", s.F01)
+	}
+	if s.F02 != true {
+		t.Errorf("F02: expected %v, got %v", true, s.F02)
+	}
+}
+
+type S5Event struct {
+	Name  string
+	At    time.Time
+	Dates []time.Time
+	Until *time.Time
+}
+
+func TestRegisterConverter(t *testing.T) {
+	d := NewDecoder()
+	d.RegisterConverter(time.Time{}, func(s string) reflect.Value {
+		if v, err := time.Parse(time.RFC3339, s); err == nil {
+			return reflect.ValueOf(v)
+		}
+		return reflect.Value{}
+	})
+	data := map[string][]string{
+		"Name":  {"launch"},
+		"At":    {"2012-08-03T10:00:00Z"},
+		"Dates": {"2012-01-01T00:00:00Z", "2012-12-31T23:59:59+01:00"},
+		"Until": {"2013-01-01T00:00:00Z"},
+	}
+	s := &S5Event{}
+	if err := d.Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e := time.Date(2012, 8, 3, 10, 0, 0, 0, time.UTC); !s.At.Equal(e) {
+		t.Errorf("At: expected %v, got %v", e, s.At)
+	}
+	if len(s.Dates) != 2 || s.Dates[1].Year() != 2012 || s.Dates[1].Month() != 12 {
+		t.Errorf("Dates: unexpected value %v", s.Dates)
+	}
+	if s.Until == nil || s.Until.Year() != 2013 {
+		t.Errorf("Until: unexpected value %v", s.Until)
+	}
+
+	// Invalid values are conversion errors.
+	err := d.Decode(&S5Event{}, map[string][]string{"At": {"yesterday"}})
+	if _, ok := err.(MultiError)["At"].(ConversionError); !ok {
+		t.Errorf("Expected a ConversionError for At, got %v", err)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+type S6 struct {
+	F01 string
+	F02 *S6Phone
+}
+
+type S6Phone struct {
+	Label  string
+	Number string
+}
+
+func TestPresenceMarker(t *testing.T) {
+	decoder := NewDecoder()
+	decoder.PresenceMarker("_present")
+
+	// No keys.
+	s := &S6{}
+	if err := decoder.Decode(s, map[string][]string{"F01": {"foo"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.F02 != nil {
+		t.Errorf("F02: expected nil, got %v", s.F02)
+	}
+
+	// Only the marker.
+	s = &S6{}
+	if err := decoder.Decode(s, map[string][]string{"F02._present": {"1"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.F02 == nil {
+		t.Errorf("F02: expected to be allocated")
+	} else if *s.F02 != (S6Phone{}) {
+		t.Errorf("F02: expected empty, got %v", *s.F02)
+	}
+
+	// Real sub-keys.
+	s = &S6{}
+	if err := decoder.Decode(s, map[string][]string{"F02.Number": {"999"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.F02 == nil {
+		t.Errorf("F02: expected to be allocated")
+	} else if s.F02.Number != "999" {
+		t.Errorf("F02.Number: expected %q, got %q", "999", s.F02.Number)
+	}
+
+	// The marker is only valid for structs.
+	s = &S6{}
+	if err := decoder.Decode(s, map[string][]string{"F01._present": {"1"}}); err == nil {
+		t.Errorf("Expected error for marker on a non-struct field")
+	}
+}
+
+func TestDecodeReporting(t *testing.T) {
+	data := map[string][]string{
+		"F02.Number": {"999"},
+		"F01":        {"foo"},
+		"F02.Label":  {""},
+	}
+	s := &S6{F02: &S6Phone{Label: "home"}}
+	setPaths, err := NewDecoder().DecodeReporting(s, data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"F01", "F02.Number"}
+	if !reflect.DeepEqual(setPaths, expected) {
+		t.Errorf("Expected set paths %v, got %v", expected, setPaths)
+	}
+	if s.F02.Label != "home" {
+		t.Errorf("F02.Label: expected %q, got %q", "home", s.F02.Label)
+	}
+
+	setPaths, err = NewDecoder().DecodeReporting(s, map[string][]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(setPaths) != 0 {
+		t.Errorf("Expected no set paths, got %v", setPaths)
+	}
+}
+
+type S7 struct {
+	F01 string
+	F02 string
+	F03 string
+}
+
+func TestBindRequest(t *testing.T) {
+	body := strings.NewReader("F01=body&F03=body")
+	r, _ := http.NewRequest("POST", "http://localhost/?F01=query&F02=query&F03=query", body)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	s := &S7{}
+	if err := BindRequest(s, r, map[string]string{"F01": "path"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.F01 != "path" {
+		t.Errorf("F01: expected %q, got %q", "path", s.F01)
+	}
+	if s.F02 != "query" {
+		t.Errorf("F02: expected %q, got %q", "query", s.F02)
+	}
+	if s.F03 != "body" {
+		t.Errorf("F03: expected %q, got %q", "body", s.F03)
+	}
+}
+
+type S8 struct {
+	F01 int
+	F02 int8
+	F03 uint16
+	F04 uint
+}
+
+func TestIntBase(t *testing.T) {
+	data := map[string][]string{
+		"F01": {"0x1F"},
+		"F02": {"0b101"},
+		"F03": {"0o17"},
+		"F04": {"42"},
+	}
+	decoder := NewDecoder()
+	decoder.IntBase(0)
+	s := &S8{}
+	if err := decoder.Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e := S8{F01: 31, F02: 5, F03: 15, F04: 42}
+	if *s != e {
+		t.Errorf("Expected %v, got %v", e, *s)
+	}
+
+	// Base 10 is the default.
+	err := NewDecoder().Decode(&S8{}, map[string][]string{"F01": {"0x1F"}})
+	if _, ok := err.(MultiError)["F01"].(ConversionError); !ok {
+		t.Errorf("Expected a ConversionError for F01, got %v", err)
+	}
+
+	// Overflows are still rejected.
+	err = decoder.Decode(&S8{}, map[string][]string{"F02": {"0xFFF"}})
+	if err == nil {
+		t.Errorf("Expected an error for an int8 overflow")
+	}
+}
+
+// rowSource is a Source backed by a database-like row.
+type rowSource struct {
+	columns []string
+	row     []string
+}
+
+func (s rowSource) Keys() []string {
+	return s.columns
+}
+
+func (s rowSource) Values(key string) ([]string, bool) {
+	for k, v := range s.columns {
+		if v == key {
+			return []string{s.row[k]}, true
+		}
+	}
+	return nil, false
+}
+
+func TestDecodeSource(t *testing.T) {
+	src := rowSource{
+		columns: []string{"F01", "F02.Label", "F02.Number"},
+		row:     []string{"foo", "home", "999"},
+	}
+	s := &S6{}
+	if err := NewDecoder().DecodeSource(s, src); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e := S6{F01: "foo", F02: &S6Phone{Label: "home", Number: "999"}}
+	if s.F01 != e.F01 || s.F02 == nil || *s.F02 != *e.F02 {
+		t.Errorf("Expected %v, got %v", e, s)
+	}
+
+	s = &S6{}
+	if err := NewDecoder().DecodeSource(s, MapSource{"F01": {"bar"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.F01 != "bar" {
+		t.Errorf("F01: expected %q, got %q", "bar", s.F01)
+	}
+}
+
+func TestDecodeWithKeyMap(t *testing.T) {
+	data := map[string][]string{
+		"user_name": {"John"},
+		"F02":       {"direct"},
+		"other":     {"mapped"},
+		"F03":       {"unchanged"},
+	}
+	keyMap := map[string]string{
+		"user_name": "F01",
+		"other":     "F02",
+	}
+	s := &S7{}
+	if err := NewDecoder().DecodeWithKeyMap(s, data, keyMap); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e := S7{F01: "John", F02: "mapped", F03: "unchanged"}
+	if *s != e {
+		t.Errorf("Expected %v, got %v", e, *s)
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	decoder := NewDecoder()
+	decoder.MaxKeys(2)
+	data := map[string][]string{
+		"F01": {"1"},
+		"F02": {"2"},
+		"F03": {"3"},
+	}
+	s := &S7{}
+	if err := decoder.Decode(s, data); err == nil {
+		t.Errorf("Expected error for too many keys")
+	}
+	if *s != (S7{}) {
+		t.Errorf("Expected no fields to be set, got %v", *s)
+	}
+
+	delete(data, "F03")
+	if err := decoder.Decode(s, data); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if s.F01 != "1" || s.F02 != "2" {
+		t.Errorf("Expected fields to be set, got %v", *s)
+	}
+}
+
+func TestMaxIndex(t *testing.T) {
+	tests := []string{
+		"grid.100000000000.0",
+		"grid.0.100000000000",
+		"cube.0.10001.0",
+	}
+	limited := NewDecoder()
+	limited.MaxIndex(10000)
+	for _, key := range tests {
+		s := &S22{}
+		err := limited.Decode(s, map[string][]string{key: {"1"}})
+		if e, ok := err.(MultiError); !ok || e[key] == nil {
+			t.Errorf("%s: expected an error, got %v", key, err)
+		}
+		if s.Grid != nil || s.Cube != nil {
+			t.Errorf("%s: expected no slice to be allocated, got %+v", key, s)
+		}
+	}
+	s1 := &S1{}
+	err := limited.Decode(s1, map[string][]string{
+		"f10.100000000000.f1": {"1"},
+	})
+	if err == nil || s1.F10 != nil {
+		t.Errorf("Expected an error for a slice of structs index, got %v", err)
+	}
+
+	// No limit by default.
+	s1 = &S1{}
+	if err := NewDecoder().Decode(s1, map[string][]string{"f10.10001.f1": {"1"}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(s1.F10) != 10002 || s1.F10[10001].F01 != 1 {
+		t.Errorf("Expected f10.10001.f1 to be set")
+	}
+
+	decoder := NewDecoder()
+	decoder.MaxIndex(2)
+	s := &S22{}
+	if err := decoder.Decode(s, map[string][]string{"grid.3.0": {"1"}}); err == nil {
+		t.Errorf("Expected an error for index 3")
+	}
+	if err := decoder.Decode(s, map[string][]string{"grid.2.0": {"1"}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(s.Grid) != 3 || s.Grid[2][0] != 1 {
+		t.Errorf("Expected grid.2.0 to be set, got %v", s.Grid)
+	}
+}
+
+type S9 struct {
+	F01 string
+	F02 []int
+	F03 S9Trimmed
+}
+
+type S9Trimmed struct {
+	F01 string
+	F02 []int
+	F03 int
+}
+
+func (S9Trimmed) SchemaOptions() Options {
+	return TrimSpace | ZeroEmpty
+}
+
+func TestStructOptions(t *testing.T) {
+	data := map[string][]string{
+		"F01":     {" foo "},
+		"F02":     {"1", "", "3"},
+		"F03.F01": {" bar "},
+		"F03.F02": {" 1", "", "3 "},
+		"F03.F03": {""},
+	}
+	s := &S9{F03: S9Trimmed{F03: 42}}
+	if err := NewDecoder().Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.F01 != " foo " {
+		t.Errorf("F01: expected %q, got %q", " foo ", s.F01)
+	}
+	if !reflect.DeepEqual(s.F02, []int{1, 3}) {
+		t.Errorf("F02: expected %v, got %v", []int{1, 3}, s.F02)
+	}
+	if s.F03.F01 != "bar" {
+		t.Errorf("F03.F01: expected %q, got %q", "bar", s.F03.F01)
+	}
+	if !reflect.DeepEqual(s.F03.F02, []int{1, 0, 3}) {
+		t.Errorf("F03.F02: expected %v, got %v", []int{1, 0, 3}, s.F03.F02)
+	}
+	if s.F03.F03 != 0 {
+		t.Errorf("F03.F03: expected 0, got %v", s.F03.F03)
+	}
+
+	// Decoder options are combined with struct options.
+	decoder := NewDecoder()
+	decoder.SetOptions(TrimSpace)
+	s = &S9{}
+	if err := decoder.Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.F01 != "foo" {
+		t.Errorf("F01: expected %q, got %q", "foo", s.F01)
+	}
+}
+
+type S10 struct {
+	Email string `schema:"email,alt=e_mail|mail"`
+	Inner S10Inner
+}
+
+type S10Inner struct {
+	Email string `schema:"email,alt=e_mail|mail"`
+}
+
+func TestAltKeys(t *testing.T) {
+	tests := []struct {
+		data     map[string][]string
+		expected string
+	}{
+		{map[string][]string{"mail": {"c"}}, "c"},
+		{map[string][]string{"e_mail": {"b"}, "mail": {"c"}}, "b"},
+		{map[string][]string{"email": {"a"}, "e_mail": {"b"}, "mail": {"c"}}, "a"},
+		{map[string][]string{"email": {""}, "mail": {"c"}}, "c"},
+		{map[string][]string{"other": {"d"}}, ""},
+	}
+	for _, test := range tests {
+		data := make(map[string][]string)
+		for k, v := range test.data {
+			data[k] = v
+			data["Inner."+k] = v
+		}
+		s := &S10{}
+		NewDecoder().Decode(s, data)
+		if s.Email != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.data, test.expected, s.Email)
+		}
+		if s.Inner.Email != test.expected {
+			t.Errorf("%v: expected Inner.Email %q, got %q", test.data, test.expected, s.Inner.Email)
+		}
+	}
+}
+
+type S11 struct {
+	F01 string `schema:"a" json:"b"`
+	F02 string `schema:"b" json:"a"`
+}
+
+func TestAliasTag(t *testing.T) {
+	data := map[string][]string{
+		"a": {"A"},
+		"b": {"B"},
+	}
+	d1 := NewDecoder()
+	d2 := NewDecoder()
+	d2.SetAliasTag("json")
+
+	s1, s2 := &S11{}, &S11{}
+	d1.Decode(s1, data)
+	d2.Decode(s2, data)
+	if e := (S11{F01: "A", F02: "B"}); *s1 != e {
+		t.Errorf("schema tag: expected %v, got %v", e, *s1)
+	}
+	if e := (S11{F01: "B", F02: "A"}); *s2 != e {
+		t.Errorf("json tag: expected %v, got %v", e, *s2)
+	}
+
+	// Meta-data cached for a tag is not used for another one.
+	d1.SetAliasTag("json")
+	s1 = &S11{}
+	d1.Decode(s1, data)
+	if e := (S11{F01: "B", F02: "A"}); *s1 != e {
+		t.Errorf("json tag after schema tag: expected %v, got %v", e, *s1)
+	}
+}
+
+type S12 struct {
+	Items *[]S12Item
+	Other *[]S12Item
+}
+
+type S12Item struct {
+	Name string
+	Sub  S12Sub
+	Subs *[]*S12Sub
+}
+
+type S12Sub struct {
+	Value int
+}
+
+func TestPointerSliceOfStructs(t *testing.T) {
+	data := map[string][]string{
+		"Items.0.Sub.Value":        {"1"},
+		"Items.1.Name":             {"second"},
+		"Items.2.Subs.1.Value":     {"21"},
+		"Items.2.Sub.Value":        {"2"},
+		"Items.2.Subs.0.Value":     {"20"},
+		"Items.2.Subs.0.Undefined": {"x"},
+	}
+	s := &S12{}
+	NewDecoder().Decode(s, data)
+	if s.Other != nil {
+		t.Errorf("Other: expected nil, got %v", s.Other)
+	}
+	if s.Items == nil {
+		t.Fatalf("Items: expected to be allocated")
+	}
+	items := *s.Items
+	if len(items) != 3 {
+		t.Fatalf("Items: expected 3 items, got %d", len(items))
+	}
+	if items[0].Sub.Value != 1 || items[0].Subs != nil {
+		t.Errorf("Items.0: got %v", items[0])
+	}
+	if items[1].Name != "second" || items[1].Subs != nil {
+		t.Errorf("Items.1: got %v", items[1])
+	}
+	if items[2].Sub.Value != 2 || items[2].Subs == nil || len(*items[2].Subs) != 2 {
+		t.Fatalf("Items.2: got %v", items[2])
+	}
+	if v := (*items[2].Subs)[0].Value; v != 20 {
+		t.Errorf("Items.2.Subs.0.Value: expected 20, got %v", v)
+	}
+	if v := (*items[2].Subs)[1].Value; v != 21 {
+		t.Errorf("Items.2.Subs.1.Value: expected 21, got %v", v)
+	}
+}
+
+type S13 struct {
+	UserName string
+	Address  S13Address
+}
+
+type S13Address struct {
+	ZipCode string `schema:"zipCode"`
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	decoder := NewDecoder()
+	decoder.SetKeyNormalizer(func(s string) string {
+		return strings.ToLower(strings.Replace(s, "_", "", -1))
+	})
+	data := map[string][]string{
+		"user_name":        {"John"},
+		"address.zip_code": {"12345"},
+	}
+	s := &S13{}
+	if err := decoder.Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.UserName != "John" {
+		t.Errorf("UserName: expected %q, got %q", "John", s.UserName)
+	}
+	if s.Address.ZipCode != "12345" {
+		t.Errorf("Address.ZipCode: expected %q, got %q", "12345", s.Address.ZipCode)
+	}
+
+	// Without a normalizer keys must match.
+	s = &S13{}
+	if err := NewDecoder().Decode(s, data); err == nil {
+		t.Errorf("Expected error for unknown keys")
+	}
+}
+
+type Pair struct {
+	Key   string
+	Value int
+}
+
+type S14 struct {
+	Fields []Pair
+	Ptrs   *[]*Pair
+}
+
+func TestSpreadSliceOfStructs(t *testing.T) {
+	data := map[string][]string{
+		"Fields.Key":   {"c", "a", "b"},
+		"Fields.Value": {"3", "1", "2"},
+		"Ptrs.Key":     {"z", "y"},
+		"Ptrs.Value":   {"26", "25"},
+	}
+	s := &S14{}
+	if err := NewDecoder().Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e := []Pair{{"c", 3}, {"a", 1}, {"b", 2}}
+	if !reflect.DeepEqual(s.Fields, e) {
+		t.Errorf("Fields: expected %v, got %v", e, s.Fields)
+	}
+	if s.Ptrs == nil || len(*s.Ptrs) != 2 {
+		t.Fatalf("Ptrs: expected 2 elements, got %v", s.Ptrs)
+	}
+	if p := (*s.Ptrs)[0]; *p != (Pair{"z", 26}) {
+		t.Errorf("Ptrs.0: expected %v, got %v", Pair{"z", 26}, *p)
+	}
+	if p := (*s.Ptrs)[1]; *p != (Pair{"y", 25}) {
+		t.Errorf("Ptrs.1: expected %v, got %v", Pair{"y", 25}, *p)
+	}
+
+	// Negative indices are invalid.
+	if err := NewDecoder().Decode(&S14{}, map[string][]string{"Fields.-1.Key": {"a"}}); err == nil {
+		t.Errorf("Expected error for a negative index")
+	}
+}
+
+type S15 struct {
+	Data  []byte `schema:"data,conv=base64"`
+	Hex   []byte `schema:"hex,conv=hex"`
+	Plain string `schema:"plain"`
+	Upper string `schema:"upper,conv=upper"`
+}
+
+func TestNamedConverter(t *testing.T) {
+	d := NewDecoder()
+	d.RegisterNamedConverter("base64", func(s string) reflect.Value {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(b)
+	})
+	d.RegisterNamedConverter("hex", func(s string) reflect.Value {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(b)
+	})
+	d.RegisterNamedConverter("upper", func(s string) reflect.Value {
+		return reflect.ValueOf(strings.ToUpper(s))
+	})
+	data := map[string][]string{
+		"data":  {"aGVsbG8="},
+		"hex":   {"68656c6c6f"},
+		"plain": {"hello"},
+		"upper": {"hello"},
+	}
+	s := &S15{}
+	if err := d.Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(s.Data) != "hello" {
+		t.Errorf("Data: expected %q, got %q", "hello", s.Data)
+	}
+	if string(s.Hex) != "hello" {
+		t.Errorf("Hex: expected %q, got %q", "hello", s.Hex)
+	}
+	if s.Plain != "hello" {
+		t.Errorf("Plain: expected %q, got %q", "hello", s.Plain)
+	}
+	if s.Upper != "HELLO" {
+		t.Errorf("Upper: expected %q, got %q", "HELLO", s.Upper)
+	}
+
+	// Invalid values are conversion errors.
+	err := d.Decode(&S15{}, map[string][]string{"hex": {"zz"}})
+	if _, ok := err.(MultiError)["hex"].(ConversionError); !ok {
+		t.Errorf("Expected a ConversionError for hex, got %v", err)
+	}
+
+	// Unregistered names are reported.
+	if err := NewDecoder().Decode(&S15{}, map[string][]string{"data": {"aGVsbG8="}}); err == nil {
+		t.Errorf("Expected error for an unregistered converter")
+	}
+}
+
+type S16 struct {
+	Name    string
+	Age     int
+	Done    chan bool
+	Email   string `schema:"email,alt=mail"`
+	Address *S16Address
+}
+
+type S16Address struct {
+	City string
+}
+
+func TestDecodeVerbose(t *testing.T) {
+	d := NewDecoder()
+	d.PresenceMarker("present")
+	data := map[string][]string{
+		"Name":            {"John"},
+		"Age":             {"forty"},
+		"Done":            {"true"},
+		"Unknown":         {"value"},
+		"email":           {"a@b.c"},
+		"mail":            {"x@y.z"},
+		"Address.present": {"1"},
+		"Address.City":    {""},
+	}
+	s := &S16{}
+	report, err := d.DecodeVerbose(s, data)
+	if err == nil {
+		t.Errorf("Expected error")
+	}
+	expected := map[string]KeyOutcome{
+		"Name":            KeySet,
+		"Age":             KeyConversionError,
+		"Done":            KeyUnsupported,
+		"Unknown":         KeyNoField,
+		"email":           KeySet,
+		"mail":            KeyShadowed,
+		"Address.present": KeyMarker,
+		"Address.City":    KeyEmpty,
+	}
+	if len(report) != len(expected) {
+		t.Errorf("Expected %d keys in the report, got %d", len(expected), len(report))
+	}
+	for k, v := range expected {
+		if report[k].Outcome != v {
+			t.Errorf("%s: expected outcome %q, got %q", k, v, report[k].Outcome)
+		}
+		if hasErr := report[k].Err != nil; hasErr != (v >= KeyNoField) {
+			t.Errorf("%s: unexpected error value %v", k, report[k].Err)
+		}
+	}
+	if _, ok := report["Age"].Err.(ConversionError); !ok {
+		t.Errorf("Age: expected a ConversionError, got %v", report["Age"].Err)
+	}
+	if s.Name != "John" || s.Email != "a@b.c" || s.Address == nil {
+		t.Errorf("Unexpected decoded struct %+v", s)
+	}
+}
+
+type S17 struct {
+	F32  float32
+	F64  float64
+	I    int
+	U    uint
+	Fs   []float64
+	Name string
+}
+
+func TestNumberFormat(t *testing.T) {
+	d := NewDecoder()
+	d.NumberFormat(",", ".")
+	data := map[string][]string{
+		"F32":  {"3,14"},
+		"F64":  {"1.000,50"},
+		"I":    {"1.000.000"},
+		"U":    {"42"},
+		"Fs":   {"0,5", "2.500,25"},
+		"Name": {"1.000,50"},
+	}
+	s := &S17{}
+	if err := d.Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e := S17{F32: 3.14, F64: 1000.5, I: 1000000, U: 42, Fs: []float64{0.5, 2500.25}, Name: "1.000,50"}
+	if !reflect.DeepEqual(*s, e) {
+		t.Errorf("Expected %+v, got %+v", e, *s)
+	}
+
+	// Values that can't be parsed are field errors.
+	err := d.Decode(&S17{}, map[string][]string{"F64": {"1,000,50"}})
+	if _, ok := err.(MultiError)["F64"].(ConversionError); !ok {
+		t.Errorf("Expected a ConversionError for F64, got %v", err)
+	}
+
+	// The default format uses a dot decimal separator.
+	s = &S17{}
+	if err := NewDecoder().Decode(s, map[string][]string{"F64": {"3.14"}, "I": {"1.000"}}); err == nil {
+		t.Errorf("Expected error for I")
+	} else if s.F64 != 3.14 {
+		t.Errorf("F64: expected 3.14, got %v", s.F64)
+	}
+}
+
+type S18Base struct {
+	ID      int
+	Created string `schema:"created"`
+	Name    string
+}
+
+type S18Meta struct {
+	Name  string
+	Owner string
+}
+
+type S18 struct {
+	*S18Base
+	S18Meta
+	Title string
+	ID    string
+}
+
+type S18Other struct {
+	Tags []string
+}
+
+type S18Nested struct {
+	S18
+	*S18Other
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	data := map[string][]string{
+		"created":      {"2012-01-01"},
+		"Owner":        {"john"},
+		"Title":        {"Hello"},
+		"ID":           {"abc"},
+		"S18Base.ID":   {"42"},
+		"S18Meta.Name": {"meta"},
+		"S18Base.Name": {"base"},
+	}
+	s := &S18{}
+	if err := NewDecoder().Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.S18Base == nil {
+		t.Fatalf("Expected S18Base to be allocated")
+	}
+	e := S18Base{ID: 42, Created: "2012-01-01", Name: "base"}
+	if *s.S18Base != e {
+		t.Errorf("S18Base: expected %+v, got %+v", e, *s.S18Base)
+	}
+	if s.Owner != "john" || s.S18Meta.Name != "meta" || s.Title != "Hello" || s.ID != "abc" {
+		t.Errorf("Unexpected decoded struct %+v", s)
+	}
+
+	// The embedded pointer is allocated once and shared by all fields.
+	s = &S18{}
+	base := &S18Base{ID: 1}
+	s.S18Base = base
+	if err := NewDecoder().Decode(s, map[string][]string{"created": {"today"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.S18Base != base || base.Created != "today" || base.ID != 1 {
+		t.Errorf("Expected the existing S18Base to be used, got %+v", s.S18Base)
+	}
+
+	// Ambiguous fields are not promoted.
+	if err := NewDecoder().Decode(&S18{}, map[string][]string{"Name": {"x"}}); err == nil {
+		t.Errorf("Expected error for an ambiguous field")
+	}
+
+	// Fields are promoted through several levels.
+	n := &S18Nested{}
+	data = map[string][]string{
+		"created": {"2012-01-01"},
+		"Tags":    {"a", "b"},
+		"Title":   {"Hello"},
+	}
+	if err := NewDecoder().Decode(n, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n.S18Base == nil || n.Created != "2012-01-01" || n.Title != "Hello" ||
+		n.S18Other == nil || !reflect.DeepEqual(n.Tags, []string{"a", "b"}) {
+		t.Errorf("Unexpected decoded struct %+v", n)
+	}
+}
+
+type S18A struct {
+	*S18B
+	Name string
+}
+
+type S18B struct {
+	*S18A
+	ID int
+}
+
+func TestMutuallyEmbeddedStructs(t *testing.T) {
+	data := map[string][]string{
+		"Name": {"a"},
+		"ID":   {"1"},
+	}
+	a := &S18A{}
+	if err := NewDecoder().Decode(a, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if a.Name != "a" || a.S18B == nil || a.ID != 1 {
+		t.Errorf("Unexpected decoded struct %+v", a)
+	}
+	b := &S18B{}
+	if err := NewDecoder().Decode(b, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if b.ID != 1 || b.S18A == nil || b.Name != "a" {
+		t.Errorf("Unexpected decoded struct %+v", b)
+	}
+}
+
+type Shape interface {
+	Area() float64
+}
+
+type Circle struct {
+	Radius float64
+}
+
+func (c *Circle) Area() float64 { return 3 * c.Radius * c.Radius }
+
+type Rect struct {
+	Width, Height float64
+}
+
+func (r Rect) Area() float64 { return r.Width * r.Height }
+
+type S19 struct {
+	Name  string
+	Shape Shape `schema:"shape"`
+	Other Shape `schema:"other,discriminator=kind"`
+}
+
+func TestRegisterImpl(t *testing.T) {
+	shapeType := reflect.TypeOf((*Shape)(nil)).Elem()
+	d := NewDecoder()
+	d.RegisterImpl(shapeType, "circle", reflect.TypeOf(&Circle{}))
+	d.RegisterImpl(shapeType, "rect", reflect.TypeOf(Rect{}))
+
+	data := map[string][]string{
+		"Name":         {"shapes"},
+		"shape.type":   {"circle"},
+		"shape.Radius": {"2"},
+		"other.kind":   {"rect"},
+		"other.Width":  {"3"},
+		"other.Height": {"4"},
+	}
+	s := &S19{}
+	if err := d.Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c, ok := s.Shape.(*Circle); !ok || c.Radius != 2 {
+		t.Errorf("Shape: expected &{2}, got %#v", s.Shape)
+	}
+	if r, ok := s.Other.(Rect); !ok || r != (Rect{3, 4}) {
+		t.Errorf("Other: expected {3 4}, got %#v", s.Other)
+	}
+
+	// An existing value of the selected type is updated.
+	c := &Circle{Radius: 1}
+	s = &S19{Shape: c}
+	if err := d.Decode(s, map[string][]string{"shape.type": {"circle"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.Shape != Shape(c) {
+		t.Errorf("Shape: expected the existing value, got %#v", s.Shape)
+	}
+
+	// Unknown or missing types are errors.
+	for _, data := range []map[string][]string{
+		{"shape.type": {"square"}, "shape.Side": {"1"}},
+		{"shape.Radius": {"1"}},
+		{"shape.type": {"circle"}, "shape.Side": {"1"}},
+	} {
+		if err := d.Decode(&S19{}, data); err == nil {
+			t.Errorf("%v: expected error", data)
+		}
+	}
+
+	// Without registered implementations interface fields are ignored.
+	if err := NewDecoder().Decode(&S19{}, data); err == nil {
+		t.Errorf("Expected error without registered implementations")
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	d := NewDecoder()
+	d.FailFast(true)
+	data := map[string][]string{
+		"F32": {"a"},
+		"F64": {"b"},
+		"I":   {"c"},
+		"U":   {"d"},
+		"Fs":  {"e"},
+	}
+	report, err := d.DecodeVerbose(&S17{}, data)
+	if _, ok := err.(ConversionError); !ok {
+		t.Fatalf("Expected a single ConversionError, got %#v", err)
+	}
+	// Processing stopped after the first error.
+	if len(report) != 1 {
+		t.Errorf("Expected 1 processed key, got %d", len(report))
+	}
+	for k, v := range report {
+		if v.Outcome != KeyConversionError || v.Err != err {
+			t.Errorf("%s: expected the returned error, got %v", k, v.Err)
+		}
+	}
+
+	// A valid key may or may not be processed before the error.
+	s := &S17{}
+	err = d.Decode(s, map[string][]string{"I": {"x"}, "Name": {"John"}})
+	if _, ok := err.(ConversionError); !ok {
+		t.Errorf("Expected a single ConversionError, got %#v", err)
+	}
+	if s.Name != "" && s.Name != "John" {
+		t.Errorf("Unexpected Name %q", s.Name)
+	}
+
+	// Without errors everything is decoded.
+	s = &S17{}
+	if err := d.Decode(s, map[string][]string{"I": {"1"}, "Name": {"John"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.I != 1 || s.Name != "John" {
+		t.Errorf("Unexpected decoded struct %+v", s)
+	}
+}
+
+type Money struct {
+	cents    int64
+	currency string
+}
+
+func parseMoney(values []string) (interface{}, error) {
+	parts := strings.Fields(values[0])
+	if len(parts) != 2 || len(parts[0]) != 3 {
+		return nil, fmt.Errorf("invalid money %q", values[0])
+	}
+	var units, cents int64
+	if _, err := fmt.Sscanf(parts[1], "%d.%02d", &units, &cents); err != nil {
+		return nil, fmt.Errorf("invalid amount %q", parts[1])
+	}
+	return Money{units*100 + cents, parts[0]}, nil
+}
+
+type S20 struct {
+	Price Money
+	Total *Money
+	Name  string
+}
+
+func TestRegisterFactory(t *testing.T) {
+	d := NewDecoder()
+	d.RegisterFactory(Money{}, parseMoney)
+	data := map[string][]string{
+		"Price": {"EUR 10.50"},
+		"Total": {"USD 1.05"},
+		"Name":  {"item"},
+	}
+	s := &S20{}
+	if err := d.Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.Price != (Money{1050, "EUR"}) {
+		t.Errorf("Price: expected {1050 EUR}, got %v", s.Price)
+	}
+	if s.Total == nil || *s.Total != (Money{105, "USD"}) {
+		t.Errorf("Total: expected {105 USD}, got %v", s.Total)
+	}
+
+	// Factory errors are field errors.
+	err := d.Decode(&S20{}, map[string][]string{"Price": {"10.50"}, "Name": {"item"}})
+	if e, ok := err.(MultiError); !ok || len(e) != 1 || e["Price"] == nil {
+		t.Errorf("Expected an error for Price, got %v", err)
+	}
+
+	// Without the factory the type is not supported.
+	if err := NewDecoder().Decode(&S20{}, map[string][]string{"Price": {"EUR 1.00"}}); err == nil {
+		t.Errorf("Expected error without a factory")
+	}
+}
+
+// Point implements encoding.TextUnmarshaler, decoding from "x,y".
+type Point struct {
+	X, Y int
+}
+
+func (p *Point) UnmarshalText(text []byte) error {
+	if _, err := fmt.Sscanf(string(text), "%d,%d", &p.X, &p.Y); err != nil {
+		return fmt.Errorf("invalid point %q", text)
+	}
+	return nil
+}
+
+// Hex is a slice type implementing encoding.TextUnmarshaler.
+type Hex []byte
+
+func (h *Hex) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	*h = b
+	return err
+}
+
+type S21 struct {
+	Origin Point
+	Target *Point
+	Path   []Point
+	Key    Hex
+	At     time.Time
+}
+
+func TestTextUnmarshaler(t *testing.T) {
+	data := map[string][]string{
+		"Origin": {"1,2"},
+		"Target": {"3,4"},
+		"Path":   {"5,6", "7,8"},
+		"Key":    {"cafe"},
+		"At":     {"2012-08-03T10:00:00Z"},
+	}
+	s := &S21{}
+	if err := NewDecoder().Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.Origin != (Point{1, 2}) {
+		t.Errorf("Origin: expected {1 2}, got %v", s.Origin)
+	}
+	if s.Target == nil || *s.Target != (Point{3, 4}) {
+		t.Errorf("Target: expected {3 4}, got %v", s.Target)
+	}
+	if !reflect.DeepEqual(s.Path, []Point{{5, 6}, {7, 8}}) {
+		t.Errorf("Path: expected [{5 6} {7 8}], got %v", s.Path)
+	}
+	if !reflect.DeepEqual(s.Key, Hex{0xca, 0xfe}) {
+		t.Errorf("Key: expected cafe, got %x", s.Key)
+	}
+	if e := time.Date(2012, 8, 3, 10, 0, 0, 0, time.UTC); !s.At.Equal(e) {
+		t.Errorf("At: expected %v, got %v", e, s.At)
+	}
+
+	// UnmarshalText errors are conversion errors.
+	data = map[string][]string{
+		"Origin": {"1"},
+		"Path":   {"5,6", "x"},
+	}
+	err := NewDecoder().Decode(&S21{}, data)
+	e, ok := err.(MultiError)
+	if !ok || len(e) != 2 {
+		t.Fatalf("Expected 2 errors, got %v", err)
+	}
+	if ce, ok := e["Origin"].(ConversionError); !ok || ce.Err == nil ||
+		ce.Index != -1 {
+		t.Errorf("Origin: expected a ConversionError, got %v", e["Origin"])
+	}
+	if ce, ok := e["Path"].(ConversionError); !ok || ce.Err == nil ||
+		ce.Index != 1 {
+		t.Errorf("Path: expected a ConversionError for index 1, got %v", e["Path"])
+	}
+}
+
+type S22 struct {
+	Grid [][]int      `schema:"grid"`
+	Cube [][][]string `schema:"cube"`
+	Rows *[][]*int    `schema:"rows"`
+}
+
+func TestMultiDimensionalSlices(t *testing.T) {
+	data := map[string][]string{
+		"grid.0.0":   {"1"},
+		"grid.0.1":   {"2"},
+		"grid.1.0":   {"3"},
+		"grid.2":     {"4", "5"},
+		"cube.1.0.1": {"x"},
+		"rows.1.0":   {"6"},
+	}
+	s := &S22{}
+	if err := NewDecoder().Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e := [][]int{{1, 2}, {3}, {4, 5}}; !reflect.DeepEqual(s.Grid, e) {
+		t.Errorf("grid: expected %v, got %v", e, s.Grid)
+	}
+	if e := [][][]string{nil, {{"", "x"}}}; !reflect.DeepEqual(s.Cube, e) {
+		t.Errorf("cube: expected %q, got %q", e, s.Cube)
+	}
+	if s.Rows == nil || len(*s.Rows) != 2 || len((*s.Rows)[1]) != 1 ||
+		*(*s.Rows)[1][0] != 6 {
+		t.Errorf("rows: unexpected value %v", s.Rows)
+	}
+
+	for _, key := range []string{"grid.x.0", "grid.0.0.0", "grid.-1.0", "cube.0.0.0.0"} {
+		err := NewDecoder().Decode(&S22{}, map[string][]string{key: {"1"}})
+		if e, ok := err.(MultiError); !ok || e[key] == nil {
+			t.Errorf("%s: expected an invalid path error, got %v", key, err)
+		}
+	}
+
+	// Conversion errors are reported for the full key.
+	err := NewDecoder().Decode(&S22{}, map[string][]string{"grid.0.0": {"a"}})
+	if e, ok := err.(MultiError); !ok || e["grid.0.0"] == nil {
+		t.Errorf("Expected a conversion error, got %v", err)
+	}
+}
+
+type S23 struct {
+	Name    string      `schema:"name,required"`
+	Age     int         `schema:"age,required"`
+	Email   string      `schema:"email,alt=mail,required"`
+	Note    string      `schema:"note"`
+	Address *S23Address `schema:"address"`
+}
+
+type S23Address struct {
+	City string `schema:"city,required"`
+	Zip  string `schema:"zip"`
+}
+
+func TestRequired(t *testing.T) {
+	tests := []struct {
+		data    map[string][]string
+		missing []string
+	}{
+		{
+			map[string][]string{"name": {"John"}, "age": {"42"}, "email": {"a@b.c"}},
+			nil,
+		},
+		{
+			// Alternative aliases satisfy required fields.
+			map[string][]string{"name": {"John"}, "age": {"42"}, "mail": {"a@b.c"}},
+			nil,
+		},
+		{
+			// Empty values don't.
+			map[string][]string{"name": {""}, "email": {"a@b.c"}, "note": {"x"}},
+			[]string{"name", "age"},
+		},
+		{
+			// Nested structs are checked if allocated.
+			map[string][]string{"name": {"John"}, "age": {"42"}, "email": {"a@b.c"},
+				"address.zip": {"12345"}},
+			[]string{"address.city"},
+		},
+	}
+	for i, test := range tests {
+		s := &S23{}
+		err := NewDecoder().Decode(s, test.data)
+		if test.missing == nil {
+			if err != nil {
+				t.Errorf("%d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		e, ok := err.(MultiError)
+		if !ok || len(e) != len(test.missing) {
+			t.Errorf("%d: expected errors for %v, got %v", i, test.missing, err)
+			continue
+		}
+		for _, key := range test.missing {
+			if e[key] == nil {
+				t.Errorf("%d: expected an error for %q, got %v", i, key, e)
+			}
+		}
+	}
+
+	// Missing keys are in the report.
+	report, _ := NewDecoder().DecodeVerbose(&S23{}, map[string][]string{"name": {"John"}})
+	if r := report["age"]; r.Outcome != KeyMissing || r.Err == nil {
+		t.Errorf("Expected age to be missing, got %v", r)
+	}
+	if r := report["name"]; r.Outcome != KeySet {
+		t.Errorf("Expected name to be set, got %v", r)
+	}
+}
+
+type S24 struct {
+	Items []S24Item  `schema:"items"`
+	Refs  []*S24Item `schema:"refs"`
+}
+
+type S24Item struct {
+	Name string
+	Qty  int
+}
+
+func TestSlicesOfStructsOutOfOrder(t *testing.T) {
+	// Keys are decoded in the given order: the largest index comes first.
+	src := rowSource{
+		columns: []string{"items.2.Name", "items.0.Name", "items.0.Qty",
+			"refs.1.Qty", "items.1.Qty", "refs.0.Name"},
+		row: []string{"c", "a", "1", "5", "2", "x"},
+	}
+	s := &S24{}
+	if err := NewDecoder().DecodeSource(s, src); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &S24{
+		Items: []S24Item{{"a", 1}, {"", 2}, {"c", 0}},
+		Refs:  []*S24Item{{"x", 0}, {"", 5}},
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, s)
+	}
+
+	// Encoded values decode back to the same slices.
+	values := map[string][]string{}
+	if err := NewEncoder().Encode(expected, values); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	s = &S24{}
+	if err := NewDecoder().Decode(s, values); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Expected %+v after a round trip, got %+v", expected, s)
+	}
+}
+
+type S25 struct {
+	Items []S25Item `schema:"items"`
+}
+
+type S25Item struct {
+	Name string
+	Tags []S25Tag
+}
+
+type S25Tag struct {
+	Label string
+}
+
+func TestIndexTokens(t *testing.T) {
+	data := map[string][]string{
+		"items.xyz.Name":          {"second"},
+		"items.abc.Name":          {"first"},
+		"items.abc.Tags.t2.Label": {"b"},
+		"items.abc.Tags.t1.Label": {"a"},
+		"items.xyz.Tags.Label":    {"c", "d"},
+	}
+	d := NewDecoder()
+	d.IndexTokens(true)
+	s := &S25{}
+	if err := d.Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []S25Item{
+		{"first", []S25Tag{{"a"}, {"b"}}},
+		// "Label" is a field of S25Tag: values are spread.
+		{"second", []S25Tag{{"c"}, {"d"}}},
+	}
+	if !reflect.DeepEqual(s.Items, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, s.Items)
+	}
+
+	// Without the option tokens are invalid paths, reported with the
+	// original keys.
+	err := NewDecoder().Decode(&S25{}, map[string][]string{"items.abc.Name": {"x"}})
+	if e, ok := err.(MultiError); !ok || e["items.abc.Name"] == nil {
+		t.Errorf("Expected an error for items.abc.Name, got %v", err)
+	}
+	err = d.Decode(&S25{}, map[string][]string{"items.abc.Missing": {"x"}})
+	if e, ok := err.(MultiError); !ok || e["items.abc.Missing"] == nil {
+		t.Errorf("Expected an error for items.abc.Missing, got %v", err)
+	}
+}
+
+type S26 struct {
+	Name   string     `schema:"name"`
+	Tags   []string   `schema:"tags"`
+	Phones []S26Phone `schema:"phones"`
+}
+
+type S26Phone struct {
+	Label  string `schema:"label"`
+	Number string `schema:"number"`
+}
+
+func TestBracketPath(t *testing.T) {
+	tests := map[string][]string{
+		"name":              {"name"},
+		"phones[0][number]": {"phones", "0", "number"},
+		"phones[0].number":  {"phones", "0", "number"},
+		"tags[]":            {"tags"},
+		"a[b][]":            {"a", "b"},
+	}
+	for key, expected := range tests {
+		if parts := BracketPath(key); !reflect.DeepEqual(parts, expected) {
+			t.Errorf("%s: expected %q, got %q", key, expected, parts)
+		}
+	}
+}
+
+func TestPathParser(t *testing.T) {
+	data := map[string][]string{
+		"name":              {"John"},
+		"tags[]":            {"a", "b"},
+		"phones[0][label]":  {"home"},
+		"phones[0][number]": {"123"},
+		"phones[1][number]": {"456"},
+	}
+	d := NewDecoder()
+	d.SetPathParser(BracketPath)
+	s := &S26{}
+	if err := d.Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &S26{
+		Name:   "John",
+		Tags:   []string{"a", "b"},
+		Phones: []S26Phone{{"home", "123"}, {"", "456"}},
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, s)
+	}
+
+	// Errors and reports use the original keys.
+	data = map[string][]string{"name": {"John"}, "phones[0][fax]": {"789"}}
+	report, err := d.DecodeVerbose(&S26{}, data)
+	if e, ok := err.(MultiError); !ok || len(e) != 1 || e["phones[0][fax]"] == nil {
+		t.Errorf("Expected an error for phones[0][fax], got %v", err)
+	}
+	if report["phones[0][fax]"].Outcome != KeyNoField || report["name"].Outcome != KeySet {
+		t.Errorf("Unexpected report %v", report)
+	}
+
+	// The dotted notation is the default.
+	if err := NewDecoder().Decode(&S26{}, map[string][]string{"phones[0][number]": {"1"}}); err == nil {
+		t.Errorf("Expected an error for bracket notation without a parser")
+	}
+}
+
+type S27 struct {
+	Name     string `schema:"name"`
+	Password string `schema:"-"`
+}
+
+func TestIgnoreUnknownKeys(t *testing.T) {
+	data := map[string][]string{
+		"name":     {"John"},
+		"Password": {"secret"},
+		"nmae":     {"typo"},
+	}
+	s := &S27{}
+	err := NewDecoder().Decode(s, data)
+	if e, ok := err.(MultiError); !ok || len(e) != 1 || e["nmae"] == nil {
+		t.Errorf("Expected an error for nmae only, got %v", err)
+	}
+	if s.Name != "John" || s.Password != "" {
+		t.Errorf("Unexpected decoded struct %+v", s)
+	}
+
+	d := NewDecoder()
+	d.IgnoreUnknownKeys(true)
+	s = &S27{}
+	report, err := d.DecodeVerbose(s, data)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if s.Name != "John" || s.Password != "" {
+		t.Errorf("Unexpected decoded struct %+v", s)
+	}
+	for _, key := range []string{"nmae", "Password"} {
+		if r := report[key]; r.Outcome != KeyNoField || r.Err != nil {
+			t.Errorf("%s: expected no field without error, got %v", key, r)
+		}
+	}
+}
+
+type Tags map[string]string
+
+type IDs []int
+
+type Lang string
+
+type S28 struct {
+	Tags   Tags             `schema:"tags"`
+	IDs    IDs              `schema:"ids"`
+	Titles map[Lang]string  `schema:"titles"`
+	Scores map[string][]int `schema:"scores"`
+	Ptr    *Tags            `schema:"ptr"`
+}
+
+func TestNamedMapAndSliceTypes(t *testing.T) {
+	data := map[string][]string{
+		"tags.env":      {"prod"},
+		"tags.app.name": {"shop"},
+		"ids":           {"1", "2", "3"},
+		"titles.en":     {"Hello"},
+		"scores.a":      {"1", "2"},
+		"ptr.x":         {"y"},
+	}
+	s := &S28{Tags: Tags{"old": "value"}}
+	if err := NewDecoder().Decode(s, data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &S28{
+		Tags:   Tags{"old": "value", "env": "prod", "app.name": "shop"},
+		IDs:    IDs{1, 2, 3},
+		Titles: map[Lang]string{"en": "Hello"},
+		Scores: map[string][]int{"a": {1, 2}},
+		Ptr:    &Tags{"x": "y"},
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, s)
+	}
+
+	// A map key is required, and conversion errors are reported.
+	for _, key := range []string{"tags", "scores.a"} {
+		err := NewDecoder().Decode(&S28{}, map[string][]string{key: {"x"}})
+		if e, ok := err.(MultiError); !ok || e[key] == nil {
+			t.Errorf("%s: expected an error, got %v", key, err)
+		}
+	}
+
+	// Maps are encoded with the map key.
+	values := map[string][]string{}
+	if err := NewEncoder().Encode(expected, values); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	s = &S28{}
+	if err := NewDecoder().Decode(s, values); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Expected %+v after a round trip, got %+v", expected, s)
+	}
+}
+
+type S29 struct {
+	Country string `schema:"country"`
+	State   string `schema:"state"`
+	Zip     string `schema:"zip"`
+}
+
+func (s *S29) Validate(set SetPaths) error {
+	if s.Country == "US" && !set.Has("state") {
+		return MultiError{"state": errors.New("state is required in the US")}
+	}
+	return nil
+}
+
+func TestValidator(t *testing.T) {
+	tests := []struct {
+		data  map[string][]string
+		valid bool
+	}{
+		{map[string][]string{"country": {"US"}, "state": {"CA"}}, true},
+		{map[string][]string{"country": {"US"}, "zip": {"94103"}}, false},
+		{map[string][]string{"country": {"US"}, "state": {""}}, false},
+		{map[string][]string{"country": {"PT"}}, true},
+	}
+	for _, test := range tests {
+		err := NewDecoder().Decode(&S29{}, test.data)
+		if test.valid && err != nil {
+			t.Errorf("%v: unexpected error: %v", test.data, err)
+		} else if !test.valid {
+			if e, ok := err.(MultiError); !ok || e["state"] == nil {
+				t.Errorf("%v: expected an error for state, got %v", test.data, err)
+			}
+		}
+	}
+
+	// Validate is not called if decoding failed.
+	err := NewDecoder().Decode(&S29{}, map[string][]string{
+		"country": {"US"},
+		"city":    {"Boston"},
+	})
+	if e, ok := err.(MultiError); !ok || len(e) != 1 || e["city"] == nil {
+		t.Errorf("Expected only an error for city, got %v", err)
+	}
+
+	// A value set before decoding is not in the set paths.
+	s := &S29{State: "NY"}
+	if err := NewDecoder().Decode(s, map[string][]string{"country": {"US"}}); err == nil {
+		t.Errorf("Expected an error for state")
+	}
+}
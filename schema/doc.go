@@ -31,6 +31,11 @@ because it caches meta-data about structs, and a instance can be shared safely:
 
 	var decoder = schema.NewDecoder()
 
+For simple programs, the package-level Decode() and RegisterConverter()
+functions use a shared decoder, schema.Default:
+
+	schema.Decode(person, values)
+
 To define custom names for fields, use a struct tag "schema". To not populate
 certain fields, use a dash for the name and it will be ignored:
 
@@ -40,6 +45,44 @@ certain fields, use a dash for the name and it will be ignored:
 		Admin bool   `schema:"-"`     // this field is never set
 	}
 
+A field can also be filled from alternative keys, listed in order of
+precedence with the "alt" option and separated by "|":
+
+	type Person struct {
+		Email string `schema:"email,alt=e_mail|mail"`
+	}
+
+The value from the first key with a non-empty value is used, starting with
+the field name: "email", then "e_mail", then "mail".
+
+Fields with the "required" option must be set with a non-empty value:
+
+	type Person struct {
+		Name string `schema:"name,required"`
+	}
+
+A missing key is reported in the returned MultiError for the field alias.
+Required fields of nested structs are only checked if the struct was
+allocated, and fields in slices of structs are not checked.
+
+For rules involving more than one field, the destination struct can
+implement the Validator interface. Validate() is called after all keys were
+decoded without errors, and receives the paths of the fields that were set:
+
+	type Address struct {
+		Country string `schema:"country"`
+		State   string `schema:"state"`
+	}
+
+	func (a *Address) Validate(set schema.SetPaths) error {
+		if a.Country == "US" && !set.Has("state") {
+			return schema.MultiError{"state": errors.New("state is required")}
+		}
+		return nil
+	}
+
+The error returned by Validate() is returned by the decoder.
+
 The supported field types in the destination struct are:
 
 	* bool
@@ -48,11 +91,35 @@ The supported field types in the destination struct are:
 	* string
 	* uint variants (uint, uint8, uint16, uint32, uint64)
 	* struct
+	* types implementing encoding.TextUnmarshaler
+	* maps with string keys, and values that are not structs or pointers;
+	  the key "Tags.color" sets the value for "color"
 	* a pointer to one of the above types
 	* a slice or a pointer to a slice of one of the above types
 
 Non-supported types are simply ignored, however custom types can be registered
-to be converted.
+to be converted. A registered converter takes precedence over UnmarshalText,
+and errors returned by UnmarshalText are reported in a ConversionError.
+
+A field can also use a converter selected by name with the "conv" option,
+which takes precedence over the converter registered for its type:
+
+	type Upload struct {
+		Data []byte `schema:"data,conv=base64"`
+	}
+
+	decoder.RegisterNamedConverter("base64", decodeBase64)
+
+Interface fields can be filled with a concrete type registered with
+Decoder.RegisterImpl(). The type is selected by name from a discriminator
+key, "type" by default, that can be changed with the "discriminator" option:
+
+	type Order struct {
+		Payment Payment `schema:"payment,discriminator=kind"`
+	}
+
+The keys "payment.kind" and "payment.Number" select the concrete type for
+Payment and set its Number field.
 
 To fill nested structs, keys must use a dotted notation as the "path" for the
 field. So for example, to fill the struct Person below:
@@ -76,6 +143,11 @@ This means that an HTML form to fill a Person struct must look like this:
 		<input type="text" name="Phone.Number">
 	</form>
 
+Fields of embedded structs, or pointers to structs, are promoted like in Go:
+they can be filled using only the field name, e.g. "ID" for a struct that
+embeds *Base with an ID field. An embedded pointer is allocated when one of
+its fields is set. The dotted notation, "Base.ID", also works.
+
 Single values are filled using the first value for a key from the source map.
 Slices are filled using all values for a key from the source map. So to fill
 a Person with multiple Phone values, like:
@@ -97,9 +169,57 @@ a Person with multiple Phone values, like:
 		<input type="text" name="Phones.2.Number">
 	</form>
 
+Keys in other notations, like "Phones[0][Label]", can be decoded setting a
+function to split them with Decoder.SetPathParser(). BracketPath is provided
+for the bracket notation.
+
 Notice that only for slices of structs the slice index is required.
 This is needed for disambiguation: if the nested struct also had a slice
 field, we could not translate multiple values to it if we did not use an
 index for the parent struct.
+
+If the index is omitted, the values for a key are spread over the slice
+elements, in the order they appear in the source map. This is the
+recommended way to decode ordered key/value pairs, because Go maps are
+unordered:
+
+	type Pair struct {
+		Key   string
+		Value string
+	}
+
+	type Sorting struct {
+		Fields []Pair
+	}
+
+...with the source map:
+
+	values := map[string][]string{
+		"Fields.Key":   {"name", "date"},
+		"Fields.Value": {"asc", "desc"},
+	}
+
+...the Fields slice will have two elements, {"name", "asc"} and
+{"date", "desc"}, in this order.
+
+With Decoder.IndexTokens(true), the index can also be a non-numeric token,
+as in "Phones.home.Number". Tokens for the same slice are sorted to assign
+the element positions.
+
+Multi-dimensional slices, such as [][]int, take an index for each
+dimension: "Grid.0.1" sets Grid[0][1]. The last index can be omitted to fill
+the innermost slice with all values for the key, as in "Grid.1".
+
+Slice indices are not limited by default. When decoding untrusted input,
+set a limit with Decoder.MaxIndex(), so that a key can't allocate a huge
+slice: greater indices are then reported as errors.
+
+The reverse operation is done by an Encoder, which fills a map, such as
+url.Values, from a struct using the same keys:
+
+	values := url.Values{}
+	err := schema.NewEncoder().Encode(person, values)
+
+Slices of structs are encoded with the element index, as in "Phones.0.Label".
 */
 package schema
@@ -0,0 +1,212 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// NewEncoder returns a new Encoder.
+func NewEncoder() *Encoder {
+	return &Encoder{cache: newCache()}
+}
+
+// Encoder encodes values from a struct to a map[string][]string.
+//
+// Keys follow the same conventions used for decoding, so a map filled by
+// Encode() decodes back to the original struct: nested structs use the
+// dotted notation and slices of structs add the element index, as in
+// "Phones.0.Number".
+type Encoder struct {
+	cache *cache
+}
+
+// SetAliasTag sets the struct tag name used to read field aliases.
+// The default is "schema".
+func (e *Encoder) SetAliasTag(tag string) {
+	e.cache.setTag(tag)
+}
+
+// Encode encodes a struct into a map[string][]string, such as url.Values.
+//
+// The first parameter must be a struct or a pointer to a struct. Fields
+// tagged with "-" and fields of unsupported types are skipped, as well as
+// nil pointers. Types implementing encoding.TextMarshaler are encoded
+// calling MarshalText. Fields using a named converter can't be encoded.
+//
+// Values are added to dst, replacing existing values for the same keys.
+// Errors for individual fields are returned in a MultiError, and the other
+// fields are still encoded.
+func (e *Encoder) Encode(src interface{}, dst map[string][]string) error {
+	v := reflect.ValueOf(src)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return errors.New("schema: interface must be a struct or a pointer to struct")
+	}
+	errs := MultiError{}
+	e.encode(v, "", dst, errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// encode encodes the fields of a struct, prefixing keys with prefix.
+func (e *Encoder) encode(v reflect.Value, prefix string,
+	dst map[string][]string, errs MultiError) {
+	info := e.cache.get(v.Type())
+	for alias, field := range info.fields {
+		// Skip alternative aliases. Promoted fields are encoded with the
+		// embedded struct.
+		if alias != e.cache.normalize(field.alias) || field.embed != nil {
+			continue
+		}
+		key := prefix + field.alias
+		fv := v.Field(field.idx)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if field.convName != "" {
+			errs[key] = fmt.Errorf("schema: can't encode field with converter %q",
+				field.convName)
+			continue
+		}
+		if field.ss {
+			for i := 0; i < fv.Len(); i++ {
+				elem := fv.Index(i)
+				if elem.Kind() == reflect.Ptr {
+					if elem.IsNil() {
+						continue
+					}
+					elem = elem.Elem()
+				}
+				e.encode(elem, fmt.Sprintf("%s.%d.", key, i), dst, errs)
+			}
+			continue
+		}
+		if field.isMap {
+			for _, k := range fv.MapKeys() {
+				mapKey := key + "." + k.String()
+				if values, err := encodeField(fv.MapIndex(k)); err != nil {
+					errs[mapKey] = err
+				} else if values != nil {
+					dst[mapKey] = values
+				}
+			}
+			continue
+		}
+		if field.dims > 0 {
+			// The innermost slice is encoded as multiple values.
+			encodeDims(fv, key, field.dims-1, dst, errs)
+			continue
+		}
+		if fv.Kind() == reflect.Struct && !isTextMarshaler(fv.Type()) {
+			e.encode(fv, key+".", dst, errs)
+			continue
+		}
+		values, err := encodeField(fv)
+		if err != nil {
+			errs[key] = err
+		} else if values != nil {
+			dst[key] = values
+		}
+	}
+}
+
+// encodeDims encodes a multi-dimensional slice, adding dims indices to the
+// key, as in "Grid.0" for a [][]int.
+func encodeDims(v reflect.Value, key string, dims int,
+	dst map[string][]string, errs MultiError) {
+	for i := 0; i < v.Len(); i++ {
+		elem := v.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				continue
+			}
+			elem = elem.Elem()
+		}
+		elemKey := fmt.Sprintf("%s.%d", key, i)
+		if dims > 1 {
+			encodeDims(elem, elemKey, dims-1, dst, errs)
+		} else if values, err := encodeField(elem); err != nil {
+			errs[elemKey] = err
+		} else if values != nil {
+			dst[elemKey] = values
+		}
+	}
+}
+
+// encodeField returns the values for a field that is not a struct.
+func encodeField(v reflect.Value) ([]string, error) {
+	if v.Kind() == reflect.Slice && !isTextMarshaler(v.Type()) {
+		var values []string
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			if elem.Kind() == reflect.Ptr {
+				if elem.IsNil() {
+					continue
+				}
+				elem = elem.Elem()
+			}
+			s, err := encodeValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+	s, err := encodeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+// encodeValue returns the string for a single value.
+func encodeValue(v reflect.Value) (string, error) {
+	if isTextMarshaler(v.Type()) {
+		if !v.Type().Implements(textMarshalerType) {
+			// MarshalText has a pointer receiver.
+			ptr := reflect.New(v.Type())
+			ptr.Elem().Set(v)
+			v = ptr
+		}
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("schema: encoder not found for %v", v.Type())
+}
+
+// isTextMarshaler returns true if t or a pointer to t implements
+// encoding.TextMarshaler.
+func isTextMarshaler(t reflect.Type) bool {
+	return reflect.PtrTo(t).Implements(textMarshalerType)
+}
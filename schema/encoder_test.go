@@ -0,0 +1,135 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type E1 struct {
+	Name    string    `schema:"name"`
+	Age     int       `schema:"age"`
+	Score   float64   `schema:"score"`
+	Active  bool      `schema:"active"`
+	Tags    []string  `schema:"tags"`
+	Count   *uint     `schema:"count"`
+	Skipped string    `schema:"-"`
+	Phone   *E1Phone  `schema:"phone"`
+	Emails  []E1Email `schema:"emails"`
+	Since   time.Time `schema:"since"`
+	Nil     *E1Phone  `schema:"nil"`
+	Done    chan bool
+}
+
+type E1Phone struct {
+	Label  string
+	Number string
+}
+
+type E1Email struct {
+	Address string `schema:"address"`
+	Primary bool   `schema:"primary"`
+}
+
+func TestEncode(t *testing.T) {
+	count := uint(3)
+	src := &E1{
+		Name:    "John",
+		Age:     42,
+		Score:   9.5,
+		Active:  true,
+		Tags:    []string{"a", "b"},
+		Count:   &count,
+		Skipped: "skipped",
+		Phone:   &E1Phone{"home", "555-1234"},
+		Emails: []E1Email{
+			{"a@b.c", true},
+			{"x@y.z", false},
+		},
+		Since: time.Date(2012, 8, 3, 10, 0, 0, 0, time.UTC),
+	}
+	dst := url.Values{}
+	if err := NewEncoder().Encode(src, dst); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := url.Values{
+		"name":             {"John"},
+		"age":              {"42"},
+		"score":            {"9.5"},
+		"active":           {"true"},
+		"tags":             {"a", "b"},
+		"count":            {"3"},
+		"phone.Label":      {"home"},
+		"phone.Number":     {"555-1234"},
+		"emails.0.address": {"a@b.c"},
+		"emails.0.primary": {"true"},
+		"emails.1.address": {"x@y.z"},
+		"emails.1.primary": {"false"},
+		"since":            {"2012-08-03T10:00:00Z"},
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Expected %v, got %v", expected, dst)
+	}
+
+	// The encoded values decode back to the original struct.
+	decoded := &E1{}
+	if err := NewDecoder().Decode(decoded, dst); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	src.Skipped = ""
+	if !reflect.DeepEqual(decoded, src) {
+		t.Errorf("Expected %+v, got %+v", src, decoded)
+	}
+
+	if err := NewEncoder().Encode("string", dst); err == nil {
+		t.Errorf("Expected error encoding a string")
+	}
+}
+
+type E2 struct {
+	Data []byte `schema:"data,conv=base64"`
+	Name string `schema:"name"`
+}
+
+func TestEncodeNamedConverter(t *testing.T) {
+	dst := map[string][]string{}
+	err := NewEncoder().Encode(E2{[]byte("data"), "name"}, dst)
+	if e, ok := err.(MultiError); !ok || len(e) != 1 || e["data"] == nil {
+		t.Errorf("Expected an error for data, got %v", err)
+	}
+	if dst["name"][0] != "name" {
+		t.Errorf("Expected name to be encoded, got %v", dst)
+	}
+}
+
+func TestEncodeMultiDimensionalSlices(t *testing.T) {
+	src := &S22{
+		Grid: [][]int{{1, 2}, {3}},
+		Cube: [][][]string{{{"a"}, {"b", "c"}}},
+	}
+	dst := map[string][]string{}
+	if err := NewEncoder().Encode(src, dst); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string][]string{
+		"grid.0":   {"1", "2"},
+		"grid.1":   {"3"},
+		"cube.0.0": {"a"},
+		"cube.0.1": {"b", "c"},
+	}
+	if !reflect.DeepEqual(dst, expected) {
+		t.Errorf("Expected %v, got %v", expected, dst)
+	}
+	decoded := &S22{}
+	if err := NewDecoder().Decode(decoded, dst); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, src) {
+		t.Errorf("Expected %+v, got %+v", src, decoded)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"sort"
+)
+
+// KeyOutcome describes what happened to a source key during decoding.
+type KeyOutcome int
+
+const (
+	// KeySet means the key was decoded to a field.
+	KeySet KeyOutcome = iota
+	// KeyEmpty means the key had no values or only an empty value, so the
+	// field was not changed.
+	KeyEmpty
+	// KeyShadowed means the key is an alternative alias for a field, and a
+	// key with higher precedence was used instead.
+	KeyShadowed
+	// KeyMarker means the key is a presence marker for a nested struct.
+	KeyMarker
+	// KeyNoField means there is no field for the key. It is an error unless
+	// the field is tagged with "-" or unknown keys are ignored.
+	KeyNoField
+	// KeyUnsupported means the field for the key has a type that can't be
+	// decoded and no converter was registered for it.
+	KeyUnsupported
+	// KeyConversionError means the value could not be converted to the
+	// field type.
+	KeyConversionError
+	// KeyError means decoding the key failed for another reason.
+	KeyError
+	// KeyMissing means the key for a required field was not in the source
+	// or had only empty values.
+	KeyMissing
+)
+
+var keyOutcomeNames = []string{
+	KeySet:             "set",
+	KeyEmpty:           "empty",
+	KeyShadowed:        "shadowed",
+	KeyMarker:          "marker",
+	KeyNoField:         "no field",
+	KeyUnsupported:     "unsupported type",
+	KeyConversionError: "conversion error",
+	KeyError:           "error",
+	KeyMissing:         "missing",
+}
+
+// String returns a readable name for the outcome.
+func (o KeyOutcome) String() string {
+	if o >= 0 && int(o) < len(keyOutcomeNames) {
+		return keyOutcomeNames[o]
+	}
+	return "unknown"
+}
+
+// KeyReport is the outcome of decoding a source key.
+type KeyReport struct {
+	Outcome KeyOutcome
+	Err     error // the error for the key, if any.
+}
+
+// Report maps source keys to their decoding outcome.
+// See Decoder.DecodeVerbose().
+type Report map[string]KeyReport
+
+// setPaths returns the sorted keys that were decoded to a field.
+func (r Report) setPaths() SetPaths {
+	paths := make(SetPaths, 0)
+	for k, v := range r {
+		if v.Outcome == KeySet {
+			paths = append(paths, k)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// SetPaths is a sorted list of the paths of the fields set by the decoder.
+type SetPaths []string
+
+// Has returns true if the field for the given path was set.
+func (s SetPaths) Has(path string) bool {
+	i := sort.SearchStrings(s, path)
+	return i < len(s) && s[i] == path
+}
+
+// Validator is implemented by structs that validate themselves after all
+// keys were decoded without errors.
+//
+// The set parameter has the paths of the fields that were set, as returned
+// by Decoder.DecodeReporting(). Fields that were not set keep their previous
+// value, so the paths allow to implement rules that depend on which fields
+// came in the source, e.g. a field required only if another one is set.
+type Validator interface {
+	Validate(set SetPaths) error
+}
@@ -0,0 +1,104 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"strings"
+)
+
+// Source provides values to be decoded. See Decoder.DecodeSource().
+type Source interface {
+	// Keys returns all keys available in the source, as "paths" in
+	// dotted notation.
+	Keys() []string
+	// Values returns the values for a key, and true if the key exists.
+	Values(key string) ([]string, bool)
+}
+
+// MapSource is a Source backed by a map[string][]string, typically
+// url.Values from an HTTP request:
+//
+//	decoder.DecodeSource(person, schema.MapSource(r.Form))
+type MapSource map[string][]string
+
+// Keys returns all keys in the map.
+func (s MapSource) Keys() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Values returns the values for a key in the map.
+func (s MapSource) Values(key string) ([]string, bool) {
+	v, ok := s[key]
+	return v, ok
+}
+
+// BracketPath splits a key in bracket notation, as used by many web
+// frameworks, into path parts: "phones[0][number]" returns
+// ["phones", "0", "number"]. Dots also separate parts, and empty brackets
+// are ignored, so "tags[]" returns ["tags"].
+//
+// It can be set as the path parser of a Decoder:
+//
+//	decoder.SetPathParser(schema.BracketPath)
+func BracketPath(key string) []string {
+	parts := make([]string, 0)
+	start := 0
+	for i := 0; i <= len(key); i++ {
+		if i == len(key) || key[i] == '[' || key[i] == ']' || key[i] == '.' {
+			if i > start {
+				parts = append(parts, key[start:i])
+			}
+			start = i + 1
+		}
+	}
+	return parts
+}
+
+// parsedSource is a Source with keys converted to dotted notation by a path
+// parser. See Decoder.SetPathParser().
+type parsedSource struct {
+	src  Source
+	keys []string
+	orig map[string]string // original keys by dotted key.
+}
+
+// newParsedSource returns a parsedSource for src using the parser fn.
+func newParsedSource(src Source, fn func(string) []string) *parsedSource {
+	s := &parsedSource{src: src, orig: make(map[string]string)}
+	for _, key := range src.Keys() {
+		dotted := strings.Join(fn(key), ".")
+		if _, ok := s.orig[dotted]; !ok {
+			s.keys = append(s.keys, dotted)
+			s.orig[dotted] = key
+		}
+	}
+	return s
+}
+
+// Keys returns the keys in dotted notation.
+func (s *parsedSource) Keys() []string {
+	return s.keys
+}
+
+// Values returns the values for a key in dotted notation.
+func (s *parsedSource) Values(key string) ([]string, bool) {
+	if orig, ok := s.orig[key]; ok {
+		return s.src.Values(orig)
+	}
+	return nil, false
+}
+
+// original returns the source key for a key in dotted notation, or the key
+// itself if it is not in the source.
+func (s *parsedSource) original(key string) string {
+	if orig, ok := s.orig[key]; ok {
+		return orig
+	}
+	return key
+}